@@ -7,12 +7,16 @@ import (
 	"io/ioutil"
 	"log"
 	"os"
+	"runtime"
 	"strconv"
 	"strings"
+
+	"github.com/campoy/advent-of-code-2019/search"
 )
 
 func main() {
 	goal := flag.Int("goal", 0, "output we expect to reach by changing noun and verb")
+	workers := flag.Int("w", runtime.NumCPU(), "number of parallel workers")
 	flag.Parse()
 
 	text, err := ioutil.ReadAll(os.Stdin)
@@ -29,30 +33,48 @@ func main() {
 		}
 	}
 
-	for noun := 0; noun < 100; noun++ {
-		for verb := 0; verb < 100; verb++ {
-			cells := make([]int, len(program))
-			copy(cells, program)
-
-			cells[1] = noun
-			cells[2] = verb
-
-			c := computer{cells: cells}
-			for !c.done {
-				if err := c.next(); err != nil {
-					log.Fatal(err)
-				}
-			}
-
-			if cells[0] == *goal {
-				fmt.Printf("noun: %d\n", noun)
-				fmt.Printf("verb: %d\n", verb)
-				return
-			}
+	s := search.Search{
+		Workers: *workers,
+		NewEvaluator: func() search.Evaluator {
+			return &evaluator{program: program, goal: *goal, c: computer{cells: make([]int, len(program))}}
+		},
+		StopOnHit: true,
+	}
+	values := make([]int, 100)
+	for i := range values {
+		values[i] = i
+	}
+	res, err := s.Run(search.Product(values, 2), nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if res == nil {
+		fmt.Println("no combination found")
+		os.Exit(1)
+	}
+	fmt.Printf("noun: %d\n", res.Candidate[0])
+	fmt.Printf("verb: %d\n", res.Candidate[1])
+}
+
+// evaluator runs the program with a noun and verb, reusing its own computer.
+type evaluator struct {
+	program []int
+	goal    int
+	c       computer
+}
+
+func (e *evaluator) Eval(candidate []int) (int, bool, error) {
+	copy(e.c.cells, e.program)
+	e.c.cells[1] = candidate[0]
+	e.c.cells[2] = candidate[1]
+	e.c.nextInst, e.c.done = 0, false
+
+	for !e.c.done {
+		if err := e.c.next(); err != nil {
+			return 0, false, err
 		}
 	}
-	fmt.Println("no combination found")
-	os.Exit(1)
+	return e.c.cells[0], e.c.cells[0] == e.goal, nil
 }
 
 type computer struct {
@@ -0,0 +1,375 @@
+package intcode
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// A BigComputer runs Intcode programs on cells holding integers of any size,
+// for programs which deliberately compute values that don't fit in an int.
+// Its channels follow the same rules as the ones of a Computer, and it can
+// be driven through Step in the same way, but it is slower and has none of
+// the debugging facilities of a Computer.
+//
+// Addresses, jump targets and relative base offsets must still fit in an
+// int, and the instruction using one that doesn't fails. Memory grows and
+// reads as 0 past the end of the program, as for a Computer, up to
+// DefaultMaxMemory cells.
+type BigComputer struct {
+	cells   []*big.Int
+	pc      int
+	relBase int
+	done    bool
+	stdin   chan *big.Int
+	stdout  chan *big.Int
+	closed  bool
+	ctx     context.Context
+	pending []*big.Int
+
+	// HaltOnEOF makes the computer halt, instead of failing with
+	// ErrInputExhausted, when it needs an input and stdin is closed.
+	HaltOnEOF bool
+}
+
+// NewBigComputer returns a computer running a copy of program.
+func NewBigComputer(program []*big.Int, stdin, stdout chan *big.Int) *BigComputer {
+	cells := make([]*big.Int, len(program))
+	for i, v := range program {
+		cells[i] = new(big.Int).Set(v)
+	}
+	return &BigComputer{cells: cells, stdin: stdin, stdout: stdout, ctx: context.Background()}
+}
+
+// ParseBig parses a program written as comma separated integers of any size.
+func ParseBig(text string) ([]*big.Int, error) {
+	var program []*big.Int
+	for _, num := range strings.Split(strings.TrimSpace(text), ",") {
+		v, ok := new(big.Int).SetString(strings.TrimSpace(num), 10)
+		if !ok {
+			return nil, fmt.Errorf("could not parse number %q", num)
+		}
+		program = append(program, v)
+	}
+	return program, nil
+}
+
+// BigEvent describes the effect of a call to BigComputer.Step, like Event.
+type BigEvent struct {
+	Kind  EventKind
+	PC    int
+	Value *big.Int // the value read or written by input and output instructions.
+}
+
+// Run runs the program until it halts or fails, and then closes stdout.
+func (c *BigComputer) Run() error { return c.RunContext(context.Background()) }
+
+// RunContext is like Run, but gives up when ctx is canceled.
+func (c *BigComputer) RunContext(ctx context.Context) error {
+	c.ctx = ctx
+	defer c.closeStdout()
+
+	for !c.done {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+		ev, err := c.Step()
+		if err != nil {
+			return err
+		}
+		if ev.Kind == NeedsInput {
+			return c.inputExhausted()
+		}
+	}
+	return nil
+}
+
+func (c *BigComputer) inputExhausted() error {
+	if !c.HaltOnEOF {
+		return ErrInputExhausted
+	}
+	c.done = true
+	c.closeStdout()
+	return nil
+}
+
+func (c *BigComputer) closeStdout() {
+	if c.stdout != nil && !c.closed {
+		close(c.stdout)
+	}
+	c.closed = true
+}
+
+// Provide queues values to be consumed by input instructions before any
+// value coming from stdin.
+func (c *BigComputer) Provide(values ...*big.Int) { c.pending = append(c.pending, values...) }
+
+// PC returns the address of the next instruction to run.
+func (c *BigComputer) PC() int { return c.pc }
+
+// Halted reports whether the program has halted.
+func (c *BigComputer) Halted() bool { return c.done }
+
+// Memory returns the memory of the computer, which is shared with it. Its
+// cells may be replaced, but must not be modified, since the cells memory
+// grows with share the same zero.
+func (c *BigComputer) Memory() []*big.Int { return c.cells }
+
+// Step runs the next instruction of the program. If it fails, the computer
+// is left as it was before the call.
+func (c *BigComputer) Step() (BigEvent, error) {
+	pc := c.pc
+	if c.done {
+		return BigEvent{Kind: Halted, PC: pc}, nil
+	}
+	ev, err := c.exec()
+	switch {
+	case err == errNeedsInput:
+		return BigEvent{Kind: NeedsInput, PC: pc}, nil
+	case err == ErrInputExhausted:
+		if err := c.inputExhausted(); err != nil {
+			return BigEvent{PC: pc}, err
+		}
+		return BigEvent{Kind: Halted, PC: pc}, nil
+	case err != nil:
+		return BigEvent{PC: pc}, err
+	}
+	ev.PC = pc
+	return ev, nil
+}
+
+// exec runs the instruction at the PC. Nothing is modified until every
+// operand has been read, so a failing instruction has no effect.
+func (c *BigComputer) exec() (BigEvent, error) {
+	if c.pc < 0 || c.pc >= len(c.cells) {
+		return BigEvent{}, fmt.Errorf("instruction pointer %d out of memory", c.pc)
+	}
+	code := c.cells[c.pc]
+	v, ok := bigToInt(code)
+	if !ok || v < 0 {
+		return BigEvent{}, fmt.Errorf("unknown op code %v", code)
+	}
+	h, err := decodeHeader(v)
+	if err != nil {
+		return BigEvent{}, err
+	}
+
+	switch op := h.op; op {
+	case opAdd, opMult, opLessThan, opEquals:
+		a, b, dest, err := c.binaryOperands(h)
+		if err != nil {
+			return BigEvent{}, err
+		}
+		res := new(big.Int)
+		switch op {
+		case opAdd:
+			res.Add(a, b)
+		case opMult:
+			res.Mul(a, b)
+		case opLessThan:
+			if a.Cmp(b) < 0 {
+				res.SetInt64(1)
+			}
+		case opEquals:
+			if a.Cmp(b) == 0 {
+				res.SetInt64(1)
+			}
+		}
+		c.store(dest, res)
+		c.pc += h.size()
+		return BigEvent{Kind: Executed}, nil
+
+	case opInput:
+		dest, err := c.dest(h, 1)
+		if err != nil {
+			return BigEvent{}, err
+		}
+		val, err := c.receive()
+		if err != nil {
+			return BigEvent{}, err
+		}
+		c.store(dest, new(big.Int).Set(val))
+		c.pc += h.size()
+		return BigEvent{Kind: Input, Value: val}, nil
+
+	case opOutput:
+		val, err := c.operand(h, 1)
+		if err != nil {
+			return BigEvent{}, err
+		}
+		val = new(big.Int).Set(val)
+		if err := c.send(val); err != nil {
+			return BigEvent{}, err
+		}
+		c.pc += h.size()
+		return BigEvent{Kind: Output, Value: val}, nil
+
+	case opJumpIfTrue, opJumpIfFalse:
+		cond, err := c.operand(h, 1)
+		if err != nil {
+			return BigEvent{}, err
+		}
+		target, err := c.operand(h, 2)
+		if err != nil {
+			return BigEvent{}, err
+		}
+		if (cond.Sign() == 0) == (op == opJumpIfTrue) {
+			c.pc += h.size()
+			return BigEvent{Kind: Executed}, nil
+		}
+		t, ok := bigToInt(target)
+		if !ok {
+			return BigEvent{}, fmt.Errorf("jump target %v out of range", target)
+		}
+		c.pc = t
+		return BigEvent{Kind: Executed}, nil
+
+	case opRelBase:
+		offset, err := c.operand(h, 1)
+		if err != nil {
+			return BigEvent{}, err
+		}
+		rb, ok := bigToInt(new(big.Int).Add(offset, big.NewInt(int64(c.relBase))))
+		if !ok {
+			return BigEvent{}, fmt.Errorf("relative base %v+%v out of range", c.relBase, offset)
+		}
+		c.relBase = rb
+		c.pc += h.size()
+		return BigEvent{Kind: Executed}, nil
+
+	case opHalt:
+		c.done = true
+		c.closeStdout()
+		return BigEvent{Kind: Halted}, nil
+	}
+	return BigEvent{}, fmt.Errorf("unknown op code %d", h.op)
+}
+
+func (c *BigComputer) binaryOperands(h opHeader) (a, b *big.Int, dest int, err error) {
+	if a, err = c.operand(h, 1); err != nil {
+		return
+	}
+	if b, err = c.operand(h, 2); err != nil {
+		return
+	}
+	dest, err = c.dest(h, 3)
+	return
+}
+
+// operand returns the value of the i-th parameter of the instruction at the
+// PC.
+func (c *BigComputer) operand(h opHeader, i int) (*big.Int, error) {
+	raw := c.cell(c.pc + i)
+	if h.modes[i-1] == immediateMode {
+		return raw, nil
+	}
+	addr, err := c.address(h, i, raw)
+	if err != nil {
+		return nil, err
+	}
+	if addr < 0 {
+		return nil, fmt.Errorf("read from negative address %d", addr)
+	}
+	return c.cell(addr), nil
+}
+
+// dest returns the address written by the i-th parameter of the instruction
+// at the PC.
+func (c *BigComputer) dest(h opHeader, i int) (int, error) {
+	if h.modes[i-1] == immediateMode {
+		return 0, errImmediateWrite
+	}
+	addr, err := c.address(h, i, c.cell(c.pc+i))
+	switch {
+	case err != nil:
+	case addr < 0:
+		err = fmt.Errorf("write to negative address %d", addr)
+	case addr >= len(c.cells) && addr >= DefaultMaxMemory:
+		err = errMemoryLimit(addr)
+	}
+	return addr, err
+}
+
+// address returns the address referred to by the i-th parameter of the
+// instruction at the PC, whose value is raw.
+func (c *BigComputer) address(h opHeader, i int, raw *big.Int) (int, error) {
+	p, ok := bigToInt(raw)
+	if !ok {
+		return 0, fmt.Errorf("address %v out of range", raw)
+	}
+	if h.modes[i-1] == relativeMode {
+		p += c.relBase
+	}
+	return p, nil
+}
+
+// cell returns the value at addr, which is 0 past the end of memory.
+func (c *BigComputer) cell(addr int) *big.Int {
+	if addr < 0 || addr >= len(c.cells) {
+		return new(big.Int)
+	}
+	return c.cells[addr]
+}
+
+// bigZero is the value of the cells memory grows with, which is never
+// modified.
+var bigZero = new(big.Int)
+
+// store sets the value at addr, growing memory if addr is past its end.
+func (c *BigComputer) store(addr int, val *big.Int) {
+	if addr >= len(c.cells) {
+		n := addr + 1
+		if n > cap(c.cells) {
+			c.cells = append(make([]*big.Int, 0, 2*n), c.cells...)
+		}
+		old := len(c.cells)
+		c.cells = c.cells[:n]
+		for i := old; i < n; i++ {
+			c.cells[i] = bigZero
+		}
+	}
+	c.cells[addr] = val
+}
+
+func bigToInt(v *big.Int) (int, bool) {
+	if !v.IsInt64() {
+		return 0, false
+	}
+	i := v.Int64()
+	return int(i), int64(int(i)) == i
+}
+
+func (c *BigComputer) receive() (*big.Int, error) {
+	if len(c.pending) > 0 {
+		val := c.pending[0]
+		c.pending = c.pending[1:]
+		return val, nil
+	}
+	if c.stdin == nil {
+		return nil, errNeedsInput
+	}
+	select {
+	case val, ok := <-c.stdin:
+		if !ok {
+			return nil, ErrInputExhausted
+		}
+		return val, nil
+	case <-c.ctx.Done():
+		return nil, c.ctx.Err()
+	}
+}
+
+func (c *BigComputer) send(val *big.Int) error {
+	if c.stdout == nil {
+		return nil
+	}
+	select {
+	case c.stdout <- val:
+		return nil
+	case <-c.ctx.Done():
+		return c.ctx.Err()
+	}
+}
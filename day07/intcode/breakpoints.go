@@ -0,0 +1,183 @@
+package intcode
+
+import "fmt"
+
+// Access is a kind of memory access, looked for by watchpoints.
+type Access int
+
+const (
+	ReadAccess Access = 1 << iota
+	WriteAccess
+)
+
+func (a Access) String() string {
+	switch a {
+	case ReadAccess:
+		return "read"
+	case WriteAccess:
+		return "write"
+	case ReadAccess | WriteAccess:
+		return "read/write"
+	}
+	return "none"
+}
+
+// WatchHit describes an access to memory watched by a watchpoint.
+type WatchHit struct {
+	Watchpoint int // identifier returned by Watch.
+	Addr       int
+	Access     Access
+	Value      int // the value read or written.
+}
+
+func (h WatchHit) String() string {
+	return fmt.Sprintf("watchpoint %d: %v of %d at %d", h.Watchpoint, h.Access, h.Value, h.Addr)
+}
+
+// AnyAddress is used with Break for breakpoints that only depend on their
+// condition, which is then checked before every instruction.
+const AnyAddress = -1
+
+// stop is either a breakpoint or a watchpoint.
+type stop struct {
+	id int
+
+	// for breakpoints.
+	addr int
+	cond *Condition
+
+	// for watchpoints.
+	from, to int
+	access   Access
+}
+
+// Break adds a breakpoint, which stops Continue and ReverseContinue before
+// the instruction at addr runs if cond, when not nil, holds at that point.
+// It returns an identifier for the breakpoint.
+func (c *Computer) Break(addr int, cond *Condition) int {
+	c.lastID++
+	c.stops = append(c.stops, stop{id: c.lastID, addr: addr, cond: cond})
+	return c.lastID
+}
+
+// Watch adds a watchpoint, which reports any access of the given kinds to
+// the cells from the address from up to the address to, both included, in
+// the Watch field of the events returned by Step. It returns an identifier
+// for the watchpoint.
+//
+// Events returned when stepping back only report writes.
+func (c *Computer) Watch(from, to int, access Access) int {
+	c.lastID++
+	c.stops = append(c.stops, stop{id: c.lastID, from: from, to: to, access: access})
+	return c.lastID
+}
+
+// Delete removes the breakpoint or watchpoint with the given identifier.
+func (c *Computer) Delete(id int) bool {
+	for i, s := range c.stops {
+		if s.id == id {
+			c.stops = append(c.stops[:i], c.stops[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Breakpoint describes a breakpoint or a watchpoint.
+type Breakpoint struct {
+	ID int
+
+	// Addr and Cond are set for breakpoints.
+	Addr int
+	Cond *Condition
+
+	// From, To and Access are set for watchpoints.
+	From, To int
+	Access   Access
+}
+
+func (b Breakpoint) String() string {
+	switch {
+	case b.Access != 0 && b.From == b.To:
+		return fmt.Sprintf("%d: watch %v at %d", b.ID, b.Access, b.From)
+	case b.Access != 0:
+		return fmt.Sprintf("%d: watch %v from %d to %d", b.ID, b.Access, b.From, b.To)
+	case b.Addr == AnyAddress:
+		return fmt.Sprintf("%d: break if %v", b.ID, b.Cond)
+	case b.Cond != nil:
+		return fmt.Sprintf("%d: break at %d if %v", b.ID, b.Addr, b.Cond)
+	}
+	return fmt.Sprintf("%d: break at %d", b.ID, b.Addr)
+}
+
+// Breakpoints lists the breakpoints and watchpoints in the order they were
+// added.
+func (c *Computer) Breakpoints() []Breakpoint {
+	bs := make([]Breakpoint, len(c.stops))
+	for i, s := range c.stops {
+		bs[i] = Breakpoint{s.id, s.addr, s.cond, s.from, s.to, s.access}
+	}
+	return bs
+}
+
+func (c *Computer) watched(addr int, access Access, val int) {
+	for _, s := range c.stops {
+		if s.access&access != 0 && s.from <= addr && addr <= s.to {
+			c.hits = append(c.hits, WatchHit{s.id, addr, access, val})
+		}
+	}
+}
+
+// AtBreakpoint returns the identifier of the first breakpoint that stops
+// the program before its next instruction, or zero if there's none.
+func (c *Computer) AtBreakpoint() (int, error) {
+	for _, s := range c.stops {
+		if s.access != 0 || (s.addr != AnyAddress && s.addr != c.nextInst) {
+			continue
+		}
+		if s.cond == nil {
+			return s.id, nil
+		}
+		ok, err := s.cond.Eval(c)
+		if err != nil {
+			return 0, fmt.Errorf("breakpoint %d: %v", s.id, err)
+		}
+		if ok {
+			return s.id, nil
+		}
+	}
+	return 0, nil
+}
+
+// Continue runs the program until it reaches a breakpoint, accesses watched
+// memory, halts or needs an input, and returns the last event.
+func (c *Computer) Continue() (Event, error) {
+	for {
+		ev, err := c.Step()
+		if err != nil || len(ev.Watch) > 0 || ev.Kind == Halted || ev.Kind == NeedsInput {
+			return ev, err
+		}
+		id, err := c.AtBreakpoint()
+		if err != nil || id != 0 {
+			ev.Breakpoint = id
+			return ev, err
+		}
+	}
+}
+
+// ReverseContinue steps back until it reaches a breakpoint or undoes a write
+// to watched memory, and returns the event of the last step undone. It fails
+// with ErrHistoryStart if it reaches the oldest step in the history.
+func (c *Computer) ReverseContinue() (Event, error) {
+	for {
+		ev, err := c.StepBack()
+		if err != nil || len(ev.Watch) > 0 {
+			return ev, err
+		}
+		id, err := c.AtBreakpoint()
+		if err != nil || id != 0 {
+			ev.Breakpoint = id
+			return ev, err
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package intcode
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"sync"
+)
+
+// A Cache memoizes the results of calls to Funcs, keyed by their program,
+// options and inputs. This is only sound because a call is deterministic: it
+// runs alone, with all of its inputs given up front, so its result depends
+// on nothing else. Failed calls are cached too, except for the ones that
+// were canceled.
+//
+// A cache holds a bounded number of results, evicting the least recently
+// used ones, and can be shared by several Funcs. It is safe for concurrent
+// use.
+type Cache struct {
+	max int
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List // of *cacheEntry, most recently used first.
+	stats   CacheStats
+}
+
+type cacheEntry struct {
+	key string
+	res Result
+	err error
+}
+
+// NewCache returns a cache holding at most size results.
+func NewCache(size int) *Cache {
+	return &Cache{max: size, entries: make(map[string]*list.Element), lru: list.New()}
+}
+
+// CacheStats counts how a cache was used.
+type CacheStats struct {
+	Hits, Misses int
+	Evictions    int
+	Entries      int // results currently held.
+}
+
+// HitRate returns the fraction of the lookups that were hits.
+func (s CacheStats) HitRate() float64 {
+	if s.Hits+s.Misses == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(s.Hits+s.Misses)
+}
+
+// Stats returns the statistics of the cache so far.
+func (c *Cache) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s := c.stats
+	s.Entries = c.lru.Len()
+	return s
+}
+
+func (c *Cache) get(key string) (cacheEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[key]
+	if !ok {
+		c.stats.Misses++
+		return cacheEntry{}, false
+	}
+	c.stats.Hits++
+	c.lru.MoveToFront(el)
+	e := *el.Value.(*cacheEntry)
+	e.res = e.res.clone()
+	return e, true
+}
+
+func (c *Cache) put(key string, res Result, err error) {
+	if c.max <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[key]; ok {
+		// Another call computed the same result meanwhile.
+		c.lru.MoveToFront(el)
+		return
+	}
+	c.entries[key] = c.lru.PushFront(&cacheEntry{key: key, res: res.clone(), err: err})
+	for c.lru.Len() > c.max {
+		el := c.lru.Back()
+		delete(c.entries, el.Value.(*cacheEntry).key)
+		c.lru.Remove(el)
+		c.stats.Evictions++
+	}
+}
+
+// clone returns a copy of the result sharing no memory with it.
+func (r Result) clone() Result {
+	r.Outputs = append([]int(nil), r.Outputs...)
+	if r.Memory != nil {
+		r.Memory = append([]int(nil), r.Memory...)
+	}
+	return r
+}
+
+// programHash returns a hash identifying program.
+func programHash(program []int) [sha256.Size]byte {
+	buf := make([]byte, 8*len(program))
+	for i, v := range program {
+		binary.LittleEndian.PutUint64(buf[8*i:], uint64(v))
+	}
+	return sha256.Sum256(buf)
+}
+
+// cacheKey returns the key of a call to f with the given inputs.
+func (f *Func) cacheKey(inputs []int) string {
+	buf := make([]byte, 0, len(f.hash)+1+binary.MaxVarintLen64*len(inputs))
+	buf = append(buf, f.hash[:]...)
+	var flags byte
+	for i, set := range []bool{f.Memory, f.HaltOnEOF, f.CheckOverflow} {
+		if set {
+			flags |= 1 << uint(i)
+		}
+	}
+	buf = append(buf, flags)
+	for _, v := range inputs {
+		var n [binary.MaxVarintLen64]byte
+		buf = append(buf, n[:binary.PutVarint(n[:], int64(v))]...)
+	}
+	return string(buf)
+}
+
+// cachedCall is CallContext for a Func with a cache.
+func (f *Func) cachedCall(ctx context.Context, inputs []int) (Result, error) {
+	key := f.cacheKey(inputs)
+	if e, ok := f.Cache.get(key); ok {
+		return e.res, e.err
+	}
+	res, err := f.call(ctx, inputs)
+	if ctx.Err() == nil {
+		f.Cache.put(key, res, err)
+	}
+	return res, err
+}
@@ -0,0 +1,176 @@
+// Command intcode-cover merges coverage profiles written by intcode
+// -coverprofile, and reports them over the disassembly of the program.
+//
+//	intcode-cover [-html report.html] [-o merged.out] program.txt profile...
+package main
+
+import (
+	"flag"
+	"fmt"
+	"html/template"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+
+	"github.com/campoy/advent-of-code-2019/day07/intcode"
+)
+
+func main() {
+	htmlPath := flag.String("html", "", "write an HTML report to this file instead of a text one to stdout")
+	out := flag.String("o", "", "write the merged profile to this file")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [flags] program.txt profile...\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() < 2 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	text, err := ioutil.ReadFile(flag.Arg(0))
+	if err != nil {
+		log.Fatal(err)
+	}
+	im, err := intcode.Load(text)
+	if err != nil {
+		log.Fatal(err)
+	}
+	program := im.Program
+
+	cov := intcode.NewCoverage(len(program))
+	for _, path := range flag.Args()[1:] {
+		if err := mergeProfile(cov, path); err != nil {
+			log.Fatalf("%s: %v", path, err)
+		}
+	}
+
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := cov.WriteProfile(f); err != nil {
+			log.Fatal(err)
+		}
+		if err := f.Close(); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	lines := cov.Listing(program)
+	if *htmlPath == "" {
+		writeText(os.Stdout, lines)
+		return
+	}
+	f, err := os.Create(*htmlPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := writeHTML(f, flag.Arg(0), lines); err != nil {
+		log.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func mergeProfile(cov *intcode.Coverage, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	profile, err := intcode.ReadCoverage(f)
+	if err != nil {
+		return err
+	}
+	return cov.Merge(profile)
+}
+
+// summary returns the number of instructions executed, and the number of
+// instructions in total.
+func summary(lines []intcode.CoverageLine) (int, int) {
+	executed, total := 0, 0
+	for _, l := range lines {
+		if !l.Instruction {
+			continue
+		}
+		total++
+		if l.Status == intcode.Covered {
+			executed++
+		}
+	}
+	return executed, total
+}
+
+var markers = map[intcode.CoverageStatus]string{
+	intcode.Covered:    "+",
+	intcode.NotCovered: "-",
+	intcode.DataOnly:   "d",
+}
+
+func writeText(w io.Writer, lines []intcode.CoverageLine) {
+	for _, l := range lines {
+		count := ""
+		if l.Count > 0 {
+			count = fmt.Sprint(l.Count)
+		}
+		fmt.Fprintf(w, "%s %8s %5d: %s\n", markers[l.Status], count, l.Addr, l.Text)
+	}
+	executed, total := summary(lines)
+	fmt.Fprintf(w, "coverage: %d of %d instructions executed (%.1f%%)\n", executed, total, percent(executed, total))
+}
+
+func percent(n, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return 100 * float64(n) / float64(total)
+}
+
+var classes = map[intcode.CoverageStatus]string{
+	intcode.Covered:    "executed",
+	intcode.NotCovered: "not-executed",
+	intcode.DataOnly:   "data",
+}
+
+var reportTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"class": func(s intcode.CoverageStatus) string { return classes[s] },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Coverage of {{.Name}}</title>
+<style>
+body { font-family: monospace; }
+td { padding: 0 1em; white-space: pre; }
+.executed { background: #cfc; }
+.not-executed { background: #fcc; }
+.data { background: #ddd; }
+</style>
+</head>
+<body>
+<h1>Coverage of {{.Name}}</h1>
+<p>{{.Executed}} of {{.Total}} instructions executed ({{printf "%.1f" .Percent}}%).
+Legend: <span class="executed">executed</span>,
+<span class="not-executed">never executed</span>,
+<span class="data">data only</span>.</p>
+<table>
+{{range .Lines}}<tr class="{{class .Status}}"><td>{{if .Count}}{{.Count}}{{end}}</td><td>{{.Addr}}</td><td>{{.Text}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+func writeHTML(w io.Writer, name string, lines []intcode.CoverageLine) error {
+	executed, total := summary(lines)
+	return reportTemplate.Execute(w, map[string]interface{}{
+		"Name":     name,
+		"Lines":    lines,
+		"Executed": executed,
+		"Total":    total,
+		"Percent":  percent(executed, total),
+	})
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/textproto"
+	"strconv"
+	"sync"
+)
+
+// The Debug Adapter Protocol exchanges JSON messages, each of them preceded
+// by a Content-Length header, as described in
+// https://microsoft.github.io/debug-adapter-protocol/overview.
+
+type request struct {
+	Seq       int             `json:"seq"`
+	Type      string          `json:"type"`
+	Command   string          `json:"command"`
+	Arguments json.RawMessage `json:"arguments,omitempty"`
+}
+
+type response struct {
+	Seq        int         `json:"seq"`
+	Type       string      `json:"type"`
+	RequestSeq int         `json:"request_seq"`
+	Success    bool        `json:"success"`
+	Command    string      `json:"command"`
+	Message    string      `json:"message,omitempty"`
+	Body       interface{} `json:"body,omitempty"`
+}
+
+type event struct {
+	Seq   int         `json:"seq"`
+	Type  string      `json:"type"`
+	Event string      `json:"event"`
+	Body  interface{} `json:"body,omitempty"`
+}
+
+// conn reads and writes DAP messages. Writes are safe for concurrent use.
+type conn struct {
+	r *textproto.Reader
+
+	mu  sync.Mutex
+	w   io.Writer
+	seq int
+}
+
+func newConn(r io.Reader, w io.Writer) *conn {
+	return &conn{r: textproto.NewReader(bufio.NewReader(r)), w: w}
+}
+
+func (c *conn) readRequest() (*request, error) {
+	header, err := c.r.ReadMIMEHeader()
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.Atoi(header.Get("Content-Length"))
+	if err != nil {
+		return nil, fmt.Errorf("bad Content-Length: %v", err)
+	}
+	body := make([]byte, n)
+	if _, err := io.ReadFull(c.r.R, body); err != nil {
+		return nil, err
+	}
+	var req request
+	if err := json.Unmarshal(body, &req); err != nil {
+		return nil, fmt.Errorf("bad request: %v", err)
+	}
+	return &req, nil
+}
+
+func (c *conn) respond(req *request, body interface{}, err error) error {
+	res := response{Type: "response", RequestSeq: req.Seq, Success: err == nil, Command: req.Command, Body: body}
+	if err != nil {
+		res.Message = err.Error()
+	}
+	return c.write(func(seq int) interface{} {
+		res.Seq = seq
+		return res
+	})
+}
+
+func (c *conn) event(name string, body interface{}) error {
+	return c.write(func(seq int) interface{} {
+		return event{Seq: seq, Type: "event", Event: name, Body: body}
+	})
+}
+
+func (c *conn) write(msg func(seq int) interface{}) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.seq++
+	body, err := json.Marshal(msg(c.seq))
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(c.w, "Content-Length: %d\r\n\r\n%s", len(body), body)
+	return err
+}
@@ -0,0 +1,73 @@
+// Command intcode-dap is a debug adapter for Intcode programs, speaking the
+// Debug Adapter Protocol over its standard input and output, or over TCP
+// when given an address on localhost to listen on.
+//
+// The launch request takes the path of the program, as text or as a binary
+// image whose symbols are then shown in the disassembly, and optionally its
+// inputs, whether to stop on entry, and the number of memory cells used to
+// record history for stepping back:
+//
+//	{"program": "input.txt", "inputs": [5], "stopOnEntry": true, "history": 1048576}
+//
+// Breakpoints can be set on addresses with instruction breakpoints, or on
+// lines of the disassembly listing available as a source. When the image has
+// a source map, they can also be set on the lines of the files it was
+// assembled from, which are relative to the directory of the image. More
+// inputs can be given from the debug console with "input 1 2 3", where any
+// other text is evaluated as an expression such as "mem[100] + 1".
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+)
+
+func main() {
+	listen := flag.String("listen", "", "address on localhost to listen on, such as localhost:4711, instead of using stdio")
+	flag.Parse()
+
+	if *listen == "" {
+		if err := serve(newConn(os.Stdin, os.Stdout)); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if err := checkLocal(*listen); err != nil {
+		log.Fatal(err)
+	}
+	l, err := net.Listen("tcp", *listen)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("listening on %s", l.Addr())
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := serve(newConn(c, c)); err != nil {
+			log.Printf("session ended: %v", err)
+		}
+		c.Close()
+	}
+}
+
+// checkLocal makes sure the address is on the loopback interface, since the
+// protocol offers no authentication.
+func checkLocal(addr string) error {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	if host == "localhost" {
+		return nil
+	}
+	if ip := net.ParseIP(host); ip == nil || !ip.IsLoopback() {
+		return fmt.Errorf("refusing to listen on %s, which is not on localhost", addr)
+	}
+	return nil
+}
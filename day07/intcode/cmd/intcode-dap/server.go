@@ -0,0 +1,599 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/campoy/advent-of-code-2019/day07/intcode"
+)
+
+const (
+	threadID = 1
+
+	// The disassembly is the source of the listing, besides the files the
+	// program was assembled from, and memory and registers are the only two
+	// scopes.
+	listingRef   = 1
+	registersRef = 1
+	memoryRef    = 2
+)
+
+// A session debugs a single program for a DAP client.
+type session struct {
+	conn *conn
+
+	c           *intcode.Computer
+	symbols     intcode.Symbols
+	stopOnEntry bool
+	listing     []int // address of the instruction on each line of the listing.
+	sources     []intcode.SourceLine
+	dir         string // directory of the program, for relative source files.
+	lineBps     []int
+	fileBps     map[string][]int // breakpoints on the lines of source files, by path.
+	instrBps    []int
+
+	// While running is set, the computer belongs to the goroutine running
+	// it, which stops as soon as paused is set.
+	running int32
+	paused  int32
+	wg      sync.WaitGroup
+}
+
+// serve handles the requests of a client until it disconnects.
+func serve(c *conn) error {
+	s := &session{conn: c}
+	defer s.pause()
+
+	for {
+		req, err := c.readRequest()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if req.Command == "disconnect" || req.Command == "terminate" {
+			s.pause()
+			if req.Command == "terminate" {
+				s.conn.event("terminated", nil)
+			}
+			return c.respond(req, nil, nil)
+		}
+		if err := s.handle(req); err != nil {
+			return err
+		}
+	}
+}
+
+// A handler returns the body of the response to a request, and optionally a
+// function to call once the response has been sent, such as one reporting
+// where the program stopped.
+type handler func(s *session, args json.RawMessage) (body interface{}, after func(), err error)
+
+var handlers = map[string]handler{
+	"initialize":                (*session).initialize,
+	"launch":                    (*session).launch,
+	"setBreakpoints":            (*session).setBreakpoints,
+	"setInstructionBreakpoints": (*session).setInstructionBreakpoints,
+	"configurationDone":         (*session).configurationDone,
+	"threads":                   (*session).threads,
+	"stackTrace":                (*session).stackTrace,
+	"source":                    (*session).source,
+	"scopes":                    (*session).scopes,
+	"variables":                 (*session).variables,
+	"evaluate":                  (*session).evaluate,
+	"continue":                  (*session).resume,
+	"next":                      (*session).next,
+	"stepIn":                    (*session).next,
+	"stepOut":                   (*session).next,
+	"stepBack":                  (*session).stepBack,
+	"reverseContinue":           (*session).reverseContinue,
+	"pause":                     (*session).pauseRequest,
+}
+
+// runningCommands can be handled while the program runs.
+var runningCommands = map[string]bool{"threads": true, "pause": true}
+
+func (s *session) handle(req *request) error {
+	h, ok := handlers[req.Command]
+	if !ok {
+		return s.conn.respond(req, nil, fmt.Errorf("unsupported command %q", req.Command))
+	}
+	if s.c == nil && req.Command != "initialize" && req.Command != "launch" {
+		return s.conn.respond(req, nil, errors.New("no program launched"))
+	}
+	if atomic.LoadInt32(&s.running) == 1 && !runningCommands[req.Command] {
+		return s.conn.respond(req, nil, errors.New("the program is running"))
+	}
+
+	body, after, err := h(s, req.Arguments)
+	if err := s.conn.respond(req, body, err); err != nil {
+		return err
+	}
+	if after != nil {
+		after()
+	}
+	return nil
+}
+
+func (s *session) initialize(json.RawMessage) (interface{}, func(), error) {
+	return capabilities, func() { s.conn.event("initialized", nil) }, nil
+}
+
+var capabilities = map[string]bool{
+	"supportsConfigurationDoneRequest": true,
+	"supportsConditionalBreakpoints":   true,
+	"supportsInstructionBreakpoints":   true,
+	"supportsStepBack":                 true,
+	"supportsTerminateRequest":         true,
+}
+
+type launchArgs struct {
+	Program     string `json:"program"`
+	Inputs      []int  `json:"inputs"`
+	StopOnEntry bool   `json:"stopOnEntry"`
+	History     int    `json:"history"`
+}
+
+func (s *session) launch(raw json.RawMessage) (interface{}, func(), error) {
+	var args launchArgs
+	if err := json.Unmarshal(raw, &args); err != nil {
+		return nil, nil, err
+	}
+	text, err := ioutil.ReadFile(args.Program)
+	if err != nil {
+		return nil, nil, err
+	}
+	im, err := intcode.Load(text)
+	if err != nil {
+		return nil, nil, err
+	}
+	program := im.Program
+	if args.History == 0 {
+		args.History = 1 << 20
+	}
+
+	s.c = intcode.NewComputer(program, nil, nil)
+	s.c.Provide(args.Inputs...)
+	s.c.RecordHistory(args.History)
+	s.symbols = im.Symbols
+	s.sources = im.Source
+	s.dir = filepath.Dir(args.Program)
+	s.fileBps = make(map[string][]int)
+	s.stopOnEntry = args.StopOnEntry
+	s.listing = nil
+	for addr := 0; addr < len(program); {
+		s.listing = append(s.listing, addr)
+		_, next, err := intcode.Disassemble(program, addr)
+		if err != nil {
+			next = addr + 1
+		}
+		addr = next
+	}
+	return nil, nil, nil
+}
+
+type sourceBreakpoint struct {
+	Line      int    `json:"line"`
+	Condition string `json:"condition"`
+}
+
+type instructionBreakpoint struct {
+	InstructionReference string `json:"instructionReference"`
+	Offset               int    `json:"offset"`
+	Condition            string `json:"condition"`
+}
+
+type breakpoint struct {
+	ID                   int    `json:"id,omitempty"`
+	Verified             bool   `json:"verified"`
+	Message              string `json:"message,omitempty"`
+	Line                 int    `json:"line,omitempty"`
+	InstructionReference string `json:"instructionReference,omitempty"`
+}
+
+type sourceArg struct {
+	Path            string `json:"path"`
+	SourceReference int    `json:"sourceReference"`
+}
+
+// setBreakpoints sets breakpoints on lines of the disassembly listing, or of
+// a file the program was assembled from, according to its source map.
+func (s *session) setBreakpoints(raw json.RawMessage) (interface{}, func(), error) {
+	var args struct {
+		Source      sourceArg          `json:"source"`
+		Breakpoints []sourceBreakpoint `json:"breakpoints"`
+	}
+	if err := json.Unmarshal(raw, &args); err != nil {
+		return nil, nil, err
+	}
+
+	addrs := make([]int, len(args.Breakpoints))
+	lines := make([]int, len(args.Breakpoints))
+	conds := make([]string, len(args.Breakpoints))
+	for i, b := range args.Breakpoints {
+		addrs[i], lines[i] = s.lineAddr(args.Source, b.Line)
+		conds[i] = b.Condition
+	}
+
+	// Breakpoints replace the previous ones of the same source, and the
+	// listing is the only source without a path.
+	var bps []breakpoint
+	if path := args.Source.Path; path != "" {
+		s.fileBps[path], bps = s.replaceBreakpoints(s.fileBps[path], addrs, conds)
+	} else {
+		s.lineBps, bps = s.replaceBreakpoints(s.lineBps, addrs, conds)
+	}
+	for i := range bps {
+		bps[i].Line = lines[i]
+	}
+	return map[string]interface{}{"breakpoints": bps}, nil, nil
+}
+
+// lineAddr returns the address of the instruction on a line of src, or on
+// the first line after it holding one, along with that line. The address is
+// -1 if there is no such instruction.
+func (s *session) lineAddr(src sourceArg, line int) (int, int) {
+	if src.Path == "" {
+		if line >= 1 && line <= len(s.listing) {
+			return s.listing[line-1], line
+		}
+		return -1, line
+	}
+	addr, found := -1, line
+	for _, sl := range s.sources {
+		if sl.Line < line || !s.sameFile(sl.File, src.Path) {
+			continue
+		}
+		if addr < 0 || sl.Line < found || (sl.Line == found && sl.Addr < addr) {
+			addr, found = sl.Addr, sl.Line
+		}
+	}
+	return addr, found
+}
+
+// sameFile reports whether a file of the source map, relative to the
+// directory of the program unless absolute, is the one at path.
+func (s *session) sameFile(file, path string) bool {
+	if !filepath.IsAbs(file) {
+		file = filepath.Join(s.dir, file)
+	}
+	a, err := filepath.Abs(file)
+	if err != nil {
+		return false
+	}
+	b, err := filepath.Abs(path)
+	return err == nil && a == b
+}
+
+// setInstructionBreakpoints sets breakpoints on addresses.
+func (s *session) setInstructionBreakpoints(raw json.RawMessage) (interface{}, func(), error) {
+	var args struct {
+		Breakpoints []instructionBreakpoint `json:"breakpoints"`
+	}
+	if err := json.Unmarshal(raw, &args); err != nil {
+		return nil, nil, err
+	}
+
+	addrs := make([]int, len(args.Breakpoints))
+	conds := make([]string, len(args.Breakpoints))
+	for i, b := range args.Breakpoints {
+		addr, err := strconv.Atoi(b.InstructionReference)
+		if err != nil {
+			addr = -1 - b.Offset
+		}
+		addrs[i] = addr + b.Offset
+		conds[i] = b.Condition
+	}
+
+	var bps []breakpoint
+	s.instrBps, bps = s.replaceBreakpoints(s.instrBps, addrs, conds)
+	for i := range bps {
+		bps[i].InstructionReference = args.Breakpoints[i].InstructionReference
+	}
+	return map[string]interface{}{"breakpoints": bps}, nil, nil
+}
+
+// replaceBreakpoints deletes the breakpoints with the identifiers in old, and
+// adds new ones at the given addresses and with the given conditions. It
+// returns the identifiers of the breakpoints added, and the result of adding
+// each of them.
+func (s *session) replaceBreakpoints(old, addrs []int, conds []string) ([]int, []breakpoint) {
+	for _, id := range old {
+		s.c.Delete(id)
+	}
+
+	var ids []int
+	bps := make([]breakpoint, len(addrs))
+	for i, addr := range addrs {
+		if addr < 0 || addr >= len(s.c.Memory()) {
+			bps[i].Message = "no instruction there"
+			continue
+		}
+		var cond *intcode.Condition
+		if conds[i] != "" {
+			var err error
+			if cond, err = intcode.ParseCondition(conds[i]); err != nil {
+				bps[i].Message = err.Error()
+				continue
+			}
+		}
+		bps[i].ID = s.c.Break(addr, cond)
+		bps[i].Verified = true
+		ids = append(ids, bps[i].ID)
+	}
+	return ids, bps
+}
+
+func (s *session) configurationDone(json.RawMessage) (interface{}, func(), error) {
+	if s.stopOnEntry {
+		return nil, func() { s.stopped("entry", "", 0) }, nil
+	}
+	return nil, s.start, nil
+}
+
+func (s *session) threads(json.RawMessage) (interface{}, func(), error) {
+	return map[string]interface{}{
+		"threads": []map[string]interface{}{{"id": threadID, "name": "intcode"}},
+	}, nil, nil
+}
+
+func (s *session) stackTrace(json.RawMessage) (interface{}, func(), error) {
+	pc := s.c.PC()
+	name, _, err := s.symbols.Disassemble(s.c.Memory(), pc)
+	if err != nil {
+		name = err.Error()
+	}
+	frame := map[string]interface{}{
+		"id":                          1,
+		"name":                        name,
+		"line":                        s.line(pc),
+		"column":                      1,
+		"source":                      listingSource,
+		"instructionPointerReference": strconv.Itoa(pc),
+	}
+	return map[string]interface{}{"stackFrames": []interface{}{frame}, "totalFrames": 1}, nil, nil
+}
+
+var listingSource = map[string]interface{}{"name": "disassembly", "sourceReference": listingRef}
+
+// line returns the line of the listing for an address, or 0 if the address
+// is not at the start of an instruction in the listing.
+func (s *session) line(addr int) int {
+	for i, a := range s.listing {
+		if a == addr {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// source returns the disassembly listing of the program as loaded, with one
+// instruction per line.
+func (s *session) source(json.RawMessage) (interface{}, func(), error) {
+	var lines []string
+	mem := s.c.Memory()
+	for _, addr := range s.listing {
+		text, _, err := s.symbols.Disassemble(mem, addr)
+		if err != nil {
+			text = fmt.Sprintf("DATA %d", mem[addr])
+		}
+		if name := s.symbols[addr]; name != "" {
+			text = name + ": " + text
+		}
+		lines = append(lines, fmt.Sprintf("%5d: %s", addr, text))
+	}
+	return map[string]interface{}{"content": strings.Join(lines, "\n"), "mimeType": "text/x-intcode"}, nil, nil
+}
+
+func (s *session) scopes(json.RawMessage) (interface{}, func(), error) {
+	return map[string]interface{}{"scopes": []map[string]interface{}{
+		{"name": "Registers", "variablesReference": registersRef, "expensive": false},
+		{"name": "Memory", "variablesReference": memoryRef, "indexedVariables": len(s.c.Memory()), "expensive": true},
+	}}, nil, nil
+}
+
+type variable struct {
+	Name               string `json:"name"`
+	Value              string `json:"value"`
+	VariablesReference int    `json:"variablesReference"`
+}
+
+func (s *session) variables(raw json.RawMessage) (interface{}, func(), error) {
+	var args struct {
+		VariablesReference int `json:"variablesReference"`
+		Start              int `json:"start"`
+		Count              int `json:"count"`
+	}
+	if err := json.Unmarshal(raw, &args); err != nil {
+		return nil, nil, err
+	}
+
+	vars := []variable{}
+	switch args.VariablesReference {
+	case registersRef:
+		vars = append(vars,
+			variable{Name: "pc", Value: strconv.Itoa(s.c.PC())},
+			variable{Name: "rb", Value: strconv.Itoa(s.c.RelativeBase())},
+			variable{Name: "steps", Value: strconv.Itoa(s.c.Steps())},
+			variable{Name: "halted", Value: strconv.FormatBool(s.c.Halted())},
+		)
+	case memoryRef:
+		mem := s.c.Memory()
+		if args.Start < 0 || args.Count < 0 {
+			return nil, nil, fmt.Errorf("bad memory range of %d cells from %d", args.Count, args.Start)
+		}
+		end := len(mem)
+		if args.Count > 0 && args.Count < end-args.Start {
+			end = args.Start + args.Count
+		}
+		for i := args.Start; i < end; i++ {
+			vars = append(vars, variable{Name: fmt.Sprintf("[%d]", i), Value: strconv.Itoa(mem[i])})
+		}
+	default:
+		return nil, nil, fmt.Errorf("unknown variables reference %d", args.VariablesReference)
+	}
+	return map[string]interface{}{"variables": vars}, nil, nil
+}
+
+// evaluate handles expressions typed in the debug console. "input 1 2 3"
+// gives inputs to the program, and anything else is evaluated as with
+// conditional breakpoints, such as "mem[12] * 2".
+func (s *session) evaluate(raw json.RawMessage) (interface{}, func(), error) {
+	var args struct {
+		Expression string `json:"expression"`
+	}
+	if err := json.Unmarshal(raw, &args); err != nil {
+		return nil, nil, err
+	}
+
+	result := ""
+	if fields := strings.Fields(args.Expression); len(fields) > 0 && fields[0] == "input" {
+		for _, f := range fields[1:] {
+			v, err := strconv.Atoi(f)
+			if err != nil {
+				return nil, nil, fmt.Errorf("bad input %q", f)
+			}
+			s.c.Provide(v)
+		}
+		result = fmt.Sprintf("%d inputs given", len(fields)-1)
+	} else {
+		cond, err := intcode.ParseCondition(args.Expression)
+		if err != nil {
+			return nil, nil, err
+		}
+		v, err := cond.Value(s.c)
+		if err != nil {
+			return nil, nil, err
+		}
+		result = strconv.Itoa(v)
+	}
+	return map[string]interface{}{"result": result, "variablesReference": 0}, nil, nil
+}
+
+func (s *session) resume(json.RawMessage) (interface{}, func(), error) {
+	return nil, s.start, nil
+}
+
+func (s *session) next(json.RawMessage) (interface{}, func(), error) {
+	return nil, func() {
+		ev, err := s.c.Step()
+		if !s.report(ev, err) {
+			s.stopped("step", "", 0)
+		}
+	}, nil
+}
+
+func (s *session) stepBack(json.RawMessage) (interface{}, func(), error) {
+	return nil, func() {
+		if _, err := s.c.StepBack(); err != nil {
+			s.stopped("step", err.Error(), 0)
+			return
+		}
+		s.stopped("step", "", 0)
+	}, nil
+}
+
+func (s *session) reverseContinue(json.RawMessage) (interface{}, func(), error) {
+	return nil, func() {
+		ev, err := s.c.ReverseContinue()
+		switch {
+		case err != nil:
+			s.stopped("step", err.Error(), 0)
+		case len(ev.Watch) > 0:
+			s.stopped("data breakpoint", ev.Watch[0].String(), 0)
+		default:
+			s.stopped("breakpoint", "", ev.Breakpoint)
+		}
+	}, nil
+}
+
+func (s *session) pauseRequest(json.RawMessage) (interface{}, func(), error) {
+	atomic.StoreInt32(&s.paused, 1)
+	return nil, nil, nil
+}
+
+// start runs the program on its own goroutine until it stops.
+func (s *session) start() {
+	atomic.StoreInt32(&s.paused, 0)
+	atomic.StoreInt32(&s.running, 1)
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		for atomic.LoadInt32(&s.paused) == 0 {
+			ev, err := s.c.Step()
+			if s.report(ev, err) {
+				return
+			}
+			id, err := s.c.AtBreakpoint()
+			if err != nil {
+				s.stopped("exception", err.Error(), 0)
+				return
+			}
+			if id != 0 {
+				s.stopped("breakpoint", "", id)
+				return
+			}
+		}
+		s.stopped("pause", "", 0)
+	}()
+}
+
+// pause stops the program if it's running, and waits for it.
+func (s *session) pause() {
+	atomic.StoreInt32(&s.paused, 1)
+	s.wg.Wait()
+}
+
+// report sends the events for a step, and returns whether the program
+// stopped.
+func (s *session) report(ev intcode.Event, err error) bool {
+	if err != nil {
+		s.stopped("exception", err.Error(), 0)
+		return true
+	}
+	switch ev.Kind {
+	case intcode.Output:
+		s.conn.event("output", map[string]interface{}{"category": "stdout", "output": fmt.Sprintln(ev.Value)})
+	case intcode.NeedsInput:
+		s.stopped("pause", "waiting for input", 0)
+		return true
+	case intcode.Halted:
+		atomic.StoreInt32(&s.running, 0)
+		s.conn.event("exited", map[string]interface{}{"exitCode": 0})
+		s.conn.event("terminated", nil)
+		return true
+	}
+	if len(ev.Watch) > 0 {
+		s.stopped("data breakpoint", ev.Watch[0].String(), 0)
+		return true
+	}
+	return false
+}
+
+// stopped reports that the program stopped. From then on, the computer can
+// be used to handle requests again.
+func (s *session) stopped(reason, text string, breakpoint int) {
+	atomic.StoreInt32(&s.running, 0)
+	body := map[string]interface{}{
+		"reason":            reason,
+		"threadId":          threadID,
+		"allThreadsStopped": true,
+	}
+	if text != "" {
+		body["text"] = text
+		body["description"] = text
+	}
+	if breakpoint != 0 {
+		body["hitBreakpointIds"] = []int{breakpoint}
+	}
+	s.conn.event("stopped", body)
+}
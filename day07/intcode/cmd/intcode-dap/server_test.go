@@ -0,0 +1,255 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// countdown reads n and outputs n, n-1, ..., 1.
+var countdown = "3,100,4,100,1001,100,-1,100,1005,100,2,99" + strings.Repeat(",0", 89)
+
+// client is a scripted DAP client.
+type client struct {
+	t   *testing.T
+	w   io.Writer
+	r   *textproto.Reader
+	seq int
+
+	// events holds the events received and not yet expected.
+	events []map[string]interface{}
+}
+
+func newClient(t *testing.T) *client {
+	serverIn, clientOut := io.Pipe()
+	clientIn, serverOut := io.Pipe()
+	go func() {
+		if err := serve(newConn(serverIn, serverOut)); err != nil {
+			t.Errorf("serve: %v", err)
+		}
+		serverOut.Close()
+	}()
+	return &client{t: t, w: clientOut, r: textproto.NewReader(bufio.NewReader(clientIn))}
+}
+
+func (c *client) read() map[string]interface{} {
+	header, err := c.r.ReadMIMEHeader()
+	if err != nil {
+		c.t.Fatalf("reading header: %v", err)
+	}
+	n, err := strconv.Atoi(header.Get("Content-Length"))
+	if err != nil {
+		c.t.Fatalf("bad header %v: %v", header, err)
+	}
+	body := make([]byte, n)
+	if _, err := io.ReadFull(c.r.R, body); err != nil {
+		c.t.Fatalf("reading body: %v", err)
+	}
+	var msg map[string]interface{}
+	if err := json.Unmarshal(body, &msg); err != nil {
+		c.t.Fatalf("bad message %s: %v", body, err)
+	}
+	return msg
+}
+
+// call sends a request and returns the body of its successful response.
+func (c *client) call(command string, args interface{}) map[string]interface{} {
+	msg := c.request(command, args)
+	if msg["success"] != true {
+		c.t.Fatalf("%s failed: %v", command, msg["message"])
+	}
+	body, _ := msg["body"].(map[string]interface{})
+	return body
+}
+
+// fail sends a request and returns the message of its failed response.
+func (c *client) fail(command string, args interface{}) string {
+	msg := c.request(command, args)
+	if msg["success"] != false {
+		c.t.Fatalf("expected %s to fail; got %v", command, msg)
+	}
+	text, _ := msg["message"].(string)
+	return text
+}
+
+// request sends a request and returns its response.
+func (c *client) request(command string, args interface{}) map[string]interface{} {
+	c.seq++
+	msg, err := json.Marshal(map[string]interface{}{
+		"seq": c.seq, "type": "request", "command": command, "arguments": args,
+	})
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	fmt.Fprintf(c.w, "Content-Length: %d\r\n\r\n%s", len(msg), msg)
+
+	for {
+		msg := c.read()
+		if msg["type"] == "event" {
+			c.events = append(c.events, msg)
+			continue
+		}
+		if msg["request_seq"] != float64(c.seq) {
+			c.t.Fatalf("unexpected response %v", msg)
+		}
+		return msg
+	}
+}
+
+// expect waits for the given event, and returns its body.
+func (c *client) expect(name string) map[string]interface{} {
+	for {
+		if len(c.events) == 0 {
+			c.events = append(c.events, c.read())
+		}
+		msg := c.events[0]
+		c.events = c.events[1:]
+		if msg["event"] == name {
+			body, _ := msg["body"].(map[string]interface{})
+			return body
+		}
+		if msg["event"] != "output" {
+			c.t.Fatalf("expected %s event; got %v", name, msg)
+		}
+	}
+}
+
+func (c *client) expectStop(reason string) map[string]interface{} {
+	body := c.expect("stopped")
+	if body["reason"] != reason {
+		c.t.Fatalf("expected to stop because of %s; got %v", reason, body)
+	}
+	return body
+}
+
+func (c *client) pc() string {
+	body := c.call("stackTrace", map[string]interface{}{"threadId": threadID})
+	frame := body["stackFrames"].([]interface{})[0].(map[string]interface{})
+	return frame["instructionPointerReference"].(string)
+}
+
+func TestSession(t *testing.T) {
+	f, err := ioutil.TempFile("", "countdown")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.WriteString(countdown); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	c := newClient(t)
+	caps := c.call("initialize", map[string]interface{}{"adapterID": "intcode"})
+	if caps["supportsStepBack"] != true {
+		t.Fatalf("expected step back to be supported; got %v", caps)
+	}
+	c.expect("initialized")
+
+	c.call("launch", map[string]interface{}{"program": f.Name(), "inputs": []int{3}, "stopOnEntry": true})
+	bps := c.call("setInstructionBreakpoints", map[string]interface{}{
+		"breakpoints": []map[string]interface{}{{"instructionReference": "8", "condition": "mem[100] == 1"}},
+	})
+	if bp := bps["breakpoints"].([]interface{})[0].(map[string]interface{}); bp["verified"] != true {
+		t.Fatalf("expected breakpoint to be verified; got %v", bp)
+	}
+	c.call("configurationDone", nil)
+	c.expectStop("entry")
+
+	// Line 3 of the listing is the ADD at address 4.
+	src := c.call("source", map[string]interface{}{"sourceReference": listingRef})
+	if line := strings.Split(src["content"].(string), "\n")[2]; !strings.Contains(line, "4: ADD") {
+		t.Fatalf("expected line 3 to be the ADD at 4; got %q", line)
+	}
+	c.call("setBreakpoints", map[string]interface{}{
+		"source":      map[string]interface{}{"sourceReference": listingRef},
+		"breakpoints": []map[string]interface{}{{"line": 3}},
+	})
+
+	c.call("continue", map[string]interface{}{"threadId": threadID})
+	if out := c.expect("output"); out["output"] != "3\n" {
+		t.Fatalf("expected output 3; got %v", out)
+	}
+	c.expectStop("breakpoint")
+	if pc := c.pc(); pc != "4" {
+		t.Fatalf("expected to stop at 4; got %s", pc)
+	}
+
+	c.call("setBreakpoints", map[string]interface{}{
+		"source":      map[string]interface{}{"sourceReference": listingRef},
+		"breakpoints": []map[string]interface{}{},
+	})
+	c.call("continue", map[string]interface{}{"threadId": threadID})
+	c.expectStop("breakpoint")
+	if v := c.call("evaluate", map[string]interface{}{"expression": "mem[100]", "context": "repl"}); v["result"] != "1" {
+		t.Fatalf("expected mem[100] to be 1; got %v", v)
+	}
+
+	c.call("stepBack", map[string]interface{}{"threadId": threadID})
+	c.expectStop("step")
+	if pc := c.pc(); pc != "4" {
+		t.Fatalf("expected to step back to 4; got %s", pc)
+	}
+	vars := c.call("variables", map[string]interface{}{"variablesReference": memoryRef, "start": 100, "count": 1})
+	if v := vars["variables"].([]interface{})[0].(map[string]interface{}); v["value"] != "2" {
+		t.Fatalf("expected [100] to be 2 after stepping back; got %v", v)
+	}
+
+	c.call("next", map[string]interface{}{"threadId": threadID})
+	c.expectStop("step")
+	c.call("continue", map[string]interface{}{"threadId": threadID})
+	c.expect("exited")
+	c.expect("terminated")
+	c.call("disconnect", nil)
+}
+
+func TestSourceBreakpoints(t *testing.T) {
+	dir, err := ioutil.TempDir("", "intcode-dap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	// Line 3 of countdown.asm is a comment.
+	image := countdown + "\n#source 0 1 countdown.asm\n#source 2 2 countdown.asm\n#source 4 4 countdown.asm\n#source 8 5 countdown.asm\n#source 11 6 countdown.asm\n"
+	path := filepath.Join(dir, "countdown.txt")
+	if err := ioutil.WriteFile(path, []byte(image), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	c := newClient(t)
+	c.call("initialize", map[string]interface{}{"adapterID": "intcode"})
+	c.expect("initialized")
+	c.call("launch", map[string]interface{}{"program": path, "inputs": []int{3}})
+	bps := c.call("setBreakpoints", map[string]interface{}{
+		"source":      map[string]interface{}{"path": filepath.Join(dir, "countdown.asm")},
+		"breakpoints": []map[string]interface{}{{"line": 3}, {"line": 7}},
+	})["breakpoints"].([]interface{})
+	if bp := bps[0].(map[string]interface{}); bp["verified"] != true || bp["line"] != 4.0 {
+		t.Fatalf("expected the breakpoint on line 3 to move to line 4; got %v", bp)
+	}
+	if bp := bps[1].(map[string]interface{}); bp["verified"] != false {
+		t.Fatalf("expected no breakpoint past the last line; got %v", bp)
+	}
+	c.call("configurationDone", nil)
+	c.expectStop("breakpoint")
+	if pc := c.pc(); pc != "4" {
+		t.Fatalf("expected to stop at 4, on line 4; got %s", pc)
+	}
+
+	if msg := c.fail("variables", map[string]interface{}{"variablesReference": memoryRef, "start": -1, "count": 2}); msg != "bad memory range of 2 cells from -1" {
+		t.Fatalf("expected a bad range; got %q", msg)
+	}
+	vars := c.call("variables", map[string]interface{}{"variablesReference": memoryRef, "start": 99, "count": 10})
+	if n := len(vars["variables"].([]interface{})); n != 2 {
+		t.Fatalf("expected the last 2 cells; got %d", n)
+	}
+	c.call("disconnect", nil)
+}
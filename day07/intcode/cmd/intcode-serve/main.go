@@ -0,0 +1,97 @@
+// Command intcode-serve runs Intcode programs for its clients over HTTP, with
+// a JSON API. Every run is limited by budgets of instructions, time and
+// memory, which a client can lower but not raise above the ones of the
+// server.
+//
+// A program is run to completion with its inputs by posting it to /run:
+//
+//	POST /run {"program": "3,9,4,9,99", "inputs": [42], "maxSteps": 1000, "timeout": "1s"}
+//
+// which answers with its outputs, the number of instructions it ran, whether
+// it halted, a hash of its final memory and the error it failed with, if any:
+//
+//	{"outputs": [42], "steps": 3, "halted": true, "memoryHash": "...", "error": ""}
+//
+// The memory of a program grows when it writes past its end, and the memory
+// budget, maxMemory, is the number of cells it may grow to, which larger
+// programs are rejected for. The outputs kept by the server count against
+// it too, one cell each. The memory hash is the hex encoded SHA-256 of
+// the cells as 64-bit little endian integers.
+//
+// Programs reading their input over time run in sessions, created by posting
+// the same request to /sessions, which answers with the id of the session.
+// The time budget of a session covers its whole life, including the time
+// spent waiting for input, and its state is kept for a while after it stops,
+// or until room is needed for new sessions. Then:
+//
+//	GET    /sessions/{id}         returns the state of the session, as /run
+//	POST   /sessions/{id}/input   streams the integers of the body, separated
+//	                              by spaces or commas, to the program; with
+//	                              ?close=true, the input then ends
+//	GET    /sessions/{id}/output  streams the outputs, one per line, from
+//	                              the one given by ?from=n
+//	GET    /sessions/{id}/ws      upgrades to a WebSocket where each text
+//	                              message holds inputs, each output is sent
+//	                              as a text message, and the server closes
+//	                              the connection with the error, if any, once
+//	                              the program stops; closing it from the
+//	                              client ends the input
+//	DELETE /sessions/{id}         stops the session and forgets it
+//
+// Since there is no authentication, the server only listens on localhost, and
+// rejects the requests whose Host or Origin is not on localhost, which may come
+// from any web page.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+func main() {
+	listen := flag.String("listen", "localhost:8080", "address on localhost to listen on")
+	maxSteps := flag.Int("max-steps", 100000000, "maximum number of instructions run by a program")
+	maxTime := flag.Duration("max-time", 10*time.Second, "maximum time a program or session runs for")
+	maxMemory := flag.Int("max-memory", 1<<20, "maximum number of memory cells used by a program")
+	maxSessions := flag.Int("max-sessions", 64, "maximum number of sessions kept at once")
+	sessionTTL := flag.Duration("session-ttl", time.Minute, "how long the state of a session is kept once it stops")
+	flag.Parse()
+
+	if err := checkLocal(*listen); err != nil {
+		log.Fatal(err)
+	}
+	s := newServer(budget{Steps: *maxSteps, Time: *maxTime, Memory: *maxMemory}, *maxSessions, *sessionTTL)
+	l, err := net.Listen("tcp", *listen)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("listening on %s", l.Addr())
+	log.Fatal(http.Serve(l, s))
+}
+
+// checkLocal makes sure the address is on the loopback interface, since the
+// API offers no authentication.
+func checkLocal(addr string) error {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	if !isLoopback(host) {
+		return fmt.Errorf("refusing to listen on %s, which is not on localhost", addr)
+	}
+	return nil
+}
+
+// isLoopback reports whether host names the loopback interface.
+func isLoopback(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(strings.Trim(host, "[]"))
+	return ip != nil && ip.IsLoopback()
+}
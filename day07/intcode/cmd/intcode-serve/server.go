@@ -0,0 +1,657 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/campoy/advent-of-code-2019/day07/intcode"
+)
+
+// maxRequestSize is the maximum size of the body of a request holding a
+// program.
+const maxRequestSize = 64 << 20
+
+// A budget limits the resources used by a program. In the budget of a
+// request, zero values stand for the limits of the server.
+type budget struct {
+	Steps  int
+	Time   time.Duration
+	Memory int
+}
+
+// within returns the budget b, lowered to the limits where it exceeds them.
+func (b budget) within(limits budget) budget {
+	if b.Steps <= 0 || b.Steps > limits.Steps {
+		b.Steps = limits.Steps
+	}
+	if b.Time <= 0 || b.Time > limits.Time {
+		b.Time = limits.Time
+	}
+	if b.Memory <= 0 || b.Memory > limits.Memory {
+		b.Memory = limits.Memory
+	}
+	return b
+}
+
+var (
+	errStepBudget   = errors.New("step budget exceeded")
+	errTimeBudget   = errors.New("time budget exceeded")
+	errMemoryBudget = errors.New("memory budget exceeded")
+)
+
+// runRequest is the body of the requests to /run and /sessions.
+type runRequest struct {
+	Program   string `json:"program"`
+	Inputs    []int  `json:"inputs"`
+	HaltOnEOF bool   `json:"haltOnEOF"`
+	MaxSteps  int    `json:"maxSteps"`
+	Timeout   string `json:"timeout"`
+	MaxMemory int    `json:"maxMemory"`
+}
+
+// result describes a run, once finished or so far.
+type result struct {
+	ID         string `json:"id,omitempty"`
+	Running    bool   `json:"running,omitempty"`
+	Outputs    []int  `json:"outputs"`
+	Steps      int    `json:"steps"`
+	Halted     bool   `json:"halted"`
+	MemoryHash string `json:"memoryHash"`
+	Error      string `json:"error,omitempty"`
+}
+
+// A server runs programs and holds sessions for its clients.
+type server struct {
+	limits      budget
+	maxSessions int
+	// ttl is how long the state of a session is kept once it stops.
+	ttl time.Duration
+
+	mu       sync.Mutex
+	sessions map[string]*session
+}
+
+func newServer(limits budget, maxSessions int, ttl time.Duration) *server {
+	return &server{limits: limits, maxSessions: maxSessions, ttl: ttl, sessions: make(map[string]*session)}
+}
+
+func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := checkOrigin(r); err != nil {
+		writeError(w, http.StatusForbidden, err)
+		return
+	}
+	path := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	switch {
+	case len(path) == 1 && path[0] == "run":
+		if r.Method != http.MethodPost {
+			methodNotAllowed(w, http.MethodPost)
+			return
+		}
+		s.run(w, r)
+	case len(path) == 1 && path[0] == "sessions":
+		if r.Method != http.MethodPost {
+			methodNotAllowed(w, http.MethodPost)
+			return
+		}
+		s.create(w, r)
+	case len(path) == 2 && path[0] == "sessions":
+		s.session(w, r, path[1], "")
+	case len(path) == 3 && path[0] == "sessions":
+		s.session(w, r, path[1], path[2])
+	default:
+		writeError(w, http.StatusNotFound, fmt.Errorf("no such endpoint %s", r.URL.Path))
+	}
+}
+
+// checkOrigin rejects the requests that may come from a web page other than
+// a local one, either through its Origin or because it was sent to a host
+// name resolving to the server, by DNS rebinding, rather than to localhost.
+func checkOrigin(r *http.Request) error {
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	if !isLoopback(host) {
+		return fmt.Errorf("forbidden host %q", r.Host)
+	}
+	if origin := r.Header.Get("Origin"); origin != "" {
+		u, err := url.Parse(origin)
+		if err != nil || !isLoopback(u.Hostname()) {
+			return fmt.Errorf("forbidden origin %q", origin)
+		}
+	}
+	return nil
+}
+
+// run runs a program to completion.
+func (s *server) run(w http.ResponseWriter, r *http.Request) {
+	c, b, err := s.load(w, r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), b.Time)
+	defer cancel()
+
+	res := result{Outputs: []int{}}
+	err = execute(ctx, c, b, nil, func(v int) { res.Outputs = append(res.Outputs, v) }, &res.Steps)
+	finish(&res, c, err)
+	writeJSON(w, http.StatusOK, res)
+}
+
+// load reads the program of a request, ready to run within the budget of the
+// request.
+func (s *server) load(w http.ResponseWriter, r *http.Request) (*intcode.Computer, budget, error) {
+	var req runRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestSize)).Decode(&req); err != nil {
+		return nil, budget{}, fmt.Errorf("could not decode request: %v", err)
+	}
+	b := budget{Steps: req.MaxSteps, Memory: req.MaxMemory}
+	if req.Timeout != "" {
+		d, err := time.ParseDuration(req.Timeout)
+		if err != nil {
+			return nil, budget{}, fmt.Errorf("bad timeout: %v", err)
+		}
+		b.Time = d
+	}
+	b = b.within(s.limits)
+
+	im, err := intcode.Load([]byte(req.Program))
+	if err != nil {
+		return nil, budget{}, err
+	}
+	if len(im.Program) > b.Memory {
+		return nil, budget{}, fmt.Errorf("program of %d cells exceeds the memory budget of %d", len(im.Program), b.Memory)
+	}
+	c := intcode.NewComputer(im.Program, nil, nil)
+	c.HaltOnEOF = req.HaltOnEOF
+	c.MaxMemory = b.Memory
+	c.Provide(req.Inputs...)
+	return c, b, nil
+}
+
+// execute runs the computer until it halts or fails, receiving any missing
+// input from input, or failing with ErrInputExhausted if nil or closed, and
+// giving every output to output. It counts the instructions run in steps.
+// The outputs are kept by the caller, so they count against the memory
+// budget along with the memory of the program.
+func execute(ctx context.Context, c *intcode.Computer, b budget, input <-chan int, output func(int), steps *int) error {
+	outputs := 0
+	for {
+		select {
+		case <-ctx.Done():
+			if ctx.Err() == context.DeadlineExceeded {
+				return errTimeBudget
+			}
+			return ctx.Err()
+		default:
+		}
+		if *steps >= b.Steps {
+			return errStepBudget
+		}
+		ev, err := step(c)
+		if errors.Is(err, intcode.ErrMemoryLimit) {
+			return errMemoryBudget
+		}
+		if err != nil {
+			return fmt.Errorf("at %d: %v", ev.PC, err)
+		}
+		if ev.Kind != intcode.NeedsInput {
+			*steps++
+		}
+		switch ev.Kind {
+		case intcode.Halted:
+			return nil
+		case intcode.Output:
+			if outputs++; len(c.Memory())+outputs > b.Memory {
+				return errMemoryBudget
+			}
+			output(ev.Value)
+		case intcode.NeedsInput:
+			if input == nil {
+				return inputExhausted(c)
+			}
+			select {
+			case v, ok := <-input:
+				if !ok {
+					input = nil
+					continue
+				}
+				c.Provide(v)
+			case <-ctx.Done():
+			}
+		}
+	}
+}
+
+// step runs the next instruction of c. As a last resort, it turns a panic
+// into an error, logged with its stack, so that a program running into a bug
+// of the computer can't bring the server down.
+func step(c *intcode.Computer) (ev intcode.Event, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("computer panicked at %d: %v\n%s", c.PC(), r, debug.Stack())
+			ev, err = intcode.Event{PC: c.PC()}, fmt.Errorf("internal error: %v", r)
+		}
+	}()
+	return c.Step()
+}
+
+// inputExhausted stops a computer needing an input that won't come, as its
+// own Run would.
+func inputExhausted(c *intcode.Computer) error {
+	if !c.HaltOnEOF {
+		return intcode.ErrInputExhausted
+	}
+	return nil
+}
+
+// finish fills in the state of a computer which stopped with err.
+func finish(res *result, c *intcode.Computer, err error) {
+	res.Halted = c.Halted() || (err == nil && c.HaltOnEOF)
+	res.MemoryHash = memoryHash(c.Memory())
+	if err != nil {
+		res.Error = err.Error()
+	}
+}
+
+// memoryHash returns the hex encoded SHA-256 of memory, with cells encoded as
+// 64-bit little endian integers.
+func memoryHash(memory []int) string {
+	h := sha256.New()
+	buf := make([]byte, 8)
+	for _, v := range memory {
+		binary.LittleEndian.PutUint64(buf, uint64(v))
+		h.Write(buf)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// A session runs a program reading its input over time.
+type session struct {
+	id     string
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	// input is read by the program. Senders hold inMu, so that it isn't
+	// closed under them.
+	input    chan int
+	inMu     sync.Mutex
+	inClosed bool
+
+	// mu guards the state of the run, and changed is closed and replaced
+	// whenever it changes.
+	mu      sync.Mutex
+	res     result
+	changed chan struct{}
+}
+
+var errStopped = errors.New("the program has stopped")
+
+// create starts a session.
+func (s *server) create(w http.ResponseWriter, r *http.Request) {
+	c, b, err := s.load(w, r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	id, err := newID()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	s.mu.Lock()
+	if len(s.sessions) >= s.maxSessions {
+		// Make room by forgetting the sessions that already stopped.
+		for id, ss := range s.sessions {
+			if ss.stopped() {
+				delete(s.sessions, id)
+			}
+		}
+	}
+	if len(s.sessions) >= s.maxSessions {
+		s.mu.Unlock()
+		writeError(w, http.StatusServiceUnavailable, fmt.Errorf("too many running sessions"))
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), b.Time)
+	ss := &session{
+		id:      id,
+		cancel:  cancel,
+		done:    make(chan struct{}),
+		input:   make(chan int),
+		res:     result{ID: id, Running: true, Outputs: []int{}},
+		changed: make(chan struct{}),
+	}
+	s.sessions[id] = ss
+	s.mu.Unlock()
+
+	go func() {
+		ss.run(ctx, c, b)
+		time.AfterFunc(s.ttl, func() { s.remove(ss) })
+	}()
+	writeJSON(w, http.StatusCreated, result{ID: id, Running: true, Outputs: []int{}})
+}
+
+func newID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// remove forgets a session, unless it was already.
+func (s *server) remove(ss *session) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.sessions[ss.id] == ss {
+		delete(s.sessions, ss.id)
+	}
+}
+
+// stopped reports whether the program of the session has stopped.
+func (ss *session) stopped() bool {
+	select {
+	case <-ss.done:
+		return true
+	default:
+		return false
+	}
+}
+
+func (ss *session) run(ctx context.Context, c *intcode.Computer, b budget) {
+	defer close(ss.done)
+	defer ss.cancel()
+
+	var steps int
+	err := execute(ctx, c, b, ss.input, func(v int) {
+		ss.update(func(res *result) { res.Outputs = append(res.Outputs, v) })
+	}, &steps)
+	ss.update(func(res *result) {
+		res.Running = false
+		res.Steps = steps
+		finish(res, c, err)
+	})
+}
+
+// update changes the state of the run and wakes up its followers.
+func (ss *session) update(f func(*result)) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	f(&ss.res)
+	close(ss.changed)
+	ss.changed = make(chan struct{})
+}
+
+// state returns a copy of the state of the run, and a channel closed when it
+// changes.
+func (ss *session) state() (result, <-chan struct{}) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	res := ss.res
+	res.Outputs = append([]int{}, ss.res.Outputs...)
+	return res, ss.changed
+}
+
+// follow calls f with each output from the from-th one, as they are produced,
+// and returns the final state once the program stops.
+func (ss *session) follow(ctx context.Context, from int, f func(int) error) (result, error) {
+	for {
+		res, changed := ss.state()
+		for ; from < len(res.Outputs); from++ {
+			if err := f(res.Outputs[from]); err != nil {
+				return res, err
+			}
+		}
+		if !res.Running {
+			return res, nil
+		}
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return res, ctx.Err()
+		}
+	}
+}
+
+// send gives an input to the program.
+func (ss *session) send(ctx context.Context, v int) error {
+	ss.inMu.Lock()
+	defer ss.inMu.Unlock()
+	if ss.inClosed {
+		return fmt.Errorf("the input has ended")
+	}
+	select {
+	case ss.input <- v:
+		return nil
+	case <-ss.done:
+		return errStopped
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// closeInput ends the input of the program.
+func (ss *session) closeInput() {
+	ss.inMu.Lock()
+	defer ss.inMu.Unlock()
+	if !ss.inClosed {
+		close(ss.input)
+		ss.inClosed = true
+	}
+}
+
+// session handles the requests to a session.
+func (s *server) session(w http.ResponseWriter, r *http.Request, id, action string) {
+	s.mu.Lock()
+	ss := s.sessions[id]
+	s.mu.Unlock()
+	if ss == nil {
+		writeError(w, http.StatusNotFound, fmt.Errorf("no session %q", id))
+		return
+	}
+
+	switch {
+	case action == "" && r.Method == http.MethodGet:
+		res, _ := ss.state()
+		writeJSON(w, http.StatusOK, res)
+	case action == "" && r.Method == http.MethodDelete:
+		s.remove(ss)
+		ss.cancel()
+		<-ss.done
+		w.WriteHeader(http.StatusNoContent)
+	case action == "":
+		methodNotAllowed(w, http.MethodGet+", "+http.MethodDelete)
+	case action == "input" && r.Method == http.MethodPost:
+		ss.handleInput(w, r)
+	case action == "input":
+		methodNotAllowed(w, http.MethodPost)
+	case action == "output" && r.Method == http.MethodGet:
+		ss.handleOutput(w, r)
+	case action == "ws" && r.Method == http.MethodGet:
+		ss.handleWebSocket(w, r)
+	case action == "output" || action == "ws":
+		methodNotAllowed(w, http.MethodGet)
+	default:
+		writeError(w, http.StatusNotFound, fmt.Errorf("no such endpoint %s", r.URL.Path))
+	}
+}
+
+// handleInput streams the integers of the body to the program as they come.
+func (ss *session) handleInput(w http.ResponseWriter, r *http.Request) {
+	accepted := 0
+	err := scanInts(r.Body, func(v int) error {
+		if err := ss.send(r.Context(), v); err != nil {
+			return err
+		}
+		accepted++
+		return nil
+	})
+	if err == nil && r.URL.Query().Get("close") == "true" {
+		ss.closeInput()
+	}
+	res := struct {
+		Accepted int    `json:"accepted"`
+		Error    string `json:"error,omitempty"`
+	}{Accepted: accepted}
+	code := http.StatusOK
+	if err != nil {
+		res.Error = err.Error()
+		code = http.StatusConflict
+	}
+	writeJSON(w, code, res)
+}
+
+// handleOutput streams the outputs of the program, one per line, flushing
+// each of them so that the client gets it right away.
+func (ss *session) handleOutput(w http.ResponseWriter, r *http.Request) {
+	from := 0
+	if v := r.URL.Query().Get("from"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("bad from %q", v))
+			return
+		}
+		from = n
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	flusher, _ := w.(http.Flusher)
+	if flusher != nil {
+		// Send the headers before the first output.
+		flusher.Flush()
+	}
+	bw := bufio.NewWriter(w)
+	ss.follow(r.Context(), from, func(v int) error {
+		if _, err := fmt.Fprintln(bw, v); err != nil {
+			return err
+		}
+		if flusher != nil {
+			if err := bw.Flush(); err != nil {
+				return err
+			}
+			flusher.Flush()
+		}
+		return nil
+	})
+	bw.Flush()
+}
+
+// handleWebSocket exchanges inputs and outputs with the client over a
+// WebSocket.
+func (ss *session) handleWebSocket(w http.ResponseWriter, r *http.Request) {
+	ws, err := upgrade(w, r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	defer ws.Close()
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	read := make(chan struct{})
+	go func() {
+		// Read the inputs until the client closes the connection, which
+		// ends the input, or goes away.
+		defer close(read)
+		defer cancel()
+		for {
+			msg, err := ws.ReadMessage()
+			if err == errClosed {
+				ss.closeInput()
+				return
+			}
+			if err != nil {
+				return
+			}
+			err = scanInts(strings.NewReader(string(msg)), func(v int) error { return ss.send(ctx, v) })
+			if err != nil && err != errStopped {
+				ws.WriteClose(closePolicyViolation, err.Error())
+				return
+			}
+		}
+	}()
+
+	res, err := ss.follow(ctx, 0, func(v int) error { return ws.WriteText(strconv.Itoa(v)) })
+	if err != nil {
+		return
+	}
+	if res.Error != "" {
+		ws.WriteClose(closeInternalError, res.Error)
+	} else {
+		ws.WriteClose(closeNormal, "halted")
+	}
+
+	// Give the client some time to answer before closing the connection.
+	select {
+	case <-read:
+	case <-time.After(time.Second):
+	}
+}
+
+// scanInts calls f with every integer read from r, separated by spaces or
+// commas, as soon as it is read.
+func scanInts(r io.Reader, f func(int) error) error {
+	s := bufio.NewScanner(r)
+	s.Split(func(data []byte, atEOF bool) (int, []byte, error) {
+		isSep := func(b byte) bool { return b == ',' || b == ' ' || b == '\t' || b == '\n' || b == '\r' }
+		start := 0
+		for start < len(data) && isSep(data[start]) {
+			start++
+		}
+		for i := start; i < len(data); i++ {
+			if isSep(data[i]) {
+				return i + 1, data[start:i], nil
+			}
+		}
+		if atEOF && start < len(data) {
+			return len(data), data[start:], nil
+		}
+		return start, nil, nil
+	})
+	for s.Scan() {
+		v, err := strconv.Atoi(s.Text())
+		if err != nil {
+			return fmt.Errorf("bad input %q", s.Text())
+		}
+		if err := f(v); err != nil {
+			return err
+		}
+	}
+	return s.Err()
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, struct {
+		Error string `json:"error"`
+	}{err.Error()})
+}
+
+func methodNotAllowed(w http.ResponseWriter, allowed string) {
+	w.Header().Set("Allow", allowed)
+	writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method not allowed"))
+}
@@ -0,0 +1,362 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// echo outputs every value it reads, forever.
+const echo = "3,7,4,7,1105,1,0,0"
+
+func newTestServer(t *testing.T) *httptest.Server {
+	return newTestServerWith(t, 4, time.Minute)
+}
+
+func newTestServerWith(t *testing.T, maxSessions int, ttl time.Duration) *httptest.Server {
+	s := httptest.NewServer(newServer(budget{Steps: 1000000, Time: 5 * time.Second, Memory: 1000}, maxSessions, ttl))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// post sends v as JSON, and decodes the response into res.
+func post(t *testing.T, url string, v, res interface{}) int {
+	body, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.Post(url, "application/json", strings.NewReader(string(body)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(res); err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode
+}
+
+func TestRun(t *testing.T) {
+	s := newTestServer(t)
+	tests := []struct {
+		name string
+		req  runRequest
+		code int
+		want result
+	}{
+		{
+			name: "halts",
+			req:  runRequest{Program: "3,9,4,9,4,9,99,0,0,0", Inputs: []int{42}},
+			code: http.StatusOK,
+			want: result{Outputs: []int{42, 42}, Steps: 4, Halted: true, MemoryHash: memoryHash([]int{3, 9, 4, 9, 4, 9, 99, 0, 0, 42})},
+		},
+		{
+			name: "input exhausted",
+			req:  runRequest{Program: echo, Inputs: []int{1}},
+			code: http.StatusOK,
+			want: result{Outputs: []int{1}, Steps: 3, MemoryHash: memoryHash([]int{3, 7, 4, 7, 1105, 1, 0, 1}), Error: "input exhausted"},
+		},
+		{
+			name: "halts on EOF",
+			req:  runRequest{Program: echo, HaltOnEOF: true},
+			code: http.StatusOK,
+			want: result{Outputs: []int{}, Halted: true, MemoryHash: memoryHash([]int{3, 7, 4, 7, 1105, 1, 0, 0})},
+		},
+		{
+			name: "step budget",
+			req:  runRequest{Program: "1105,1,0", MaxSteps: 100},
+			code: http.StatusOK,
+			want: result{Outputs: []int{}, Steps: 100, MemoryHash: memoryHash([]int{1105, 1, 0}), Error: "step budget exceeded"},
+		},
+		{
+			name: "program larger than the memory budget",
+			req:  runRequest{Program: "1105,1,0", MaxMemory: 2},
+			code: http.StatusBadRequest,
+			want: result{Error: "program of 3 cells exceeds the memory budget of 2"},
+		},
+		{
+			name: "memory grows",
+			req:  runRequest{Program: "109,10,21101,1,2,5,204,5,99"},
+			code: http.StatusOK,
+			want: result{Outputs: []int{3}, Steps: 4, Halted: true, MemoryHash: memoryHash([]int{109, 10, 21101, 1, 2, 5, 204, 5, 99, 0, 0, 0, 0, 0, 0, 3})},
+		},
+		{
+			name: "memory budget",
+			req:  runRequest{Program: "1101,1,1,100,99", MaxMemory: 50},
+			code: http.StatusOK,
+			want: result{Outputs: []int{}, MemoryHash: memoryHash([]int{1101, 1, 1, 100, 99}), Error: "memory budget exceeded"},
+		},
+		{
+			name: "write to the largest address",
+			req:  runRequest{Program: "1101,1,2,9223372036854775807,99"},
+			code: http.StatusOK,
+			want: result{Outputs: []int{}, MemoryHash: memoryHash([]int{1101, 1, 2, 9223372036854775807, 99}), Error: "memory budget exceeded"},
+		},
+		{
+			name: "outputs count against the memory budget",
+			req:  runRequest{Program: "104,1,1105,1,0", MaxMemory: 10},
+			code: http.StatusOK,
+			want: result{Outputs: []int{1, 1, 1, 1, 1}, Steps: 11, MemoryHash: memoryHash([]int{104, 1, 1105, 1, 0}), Error: "memory budget exceeded"},
+		},
+		{
+			name: "immediate write",
+			req:  runRequest{Program: "11101,1,1,3,99"},
+			code: http.StatusOK,
+			want: result{Outputs: []int{}, MemoryHash: memoryHash([]int{11101, 1, 1, 3, 99}), Error: "at 0: wrote into an immediate parameter"},
+		},
+		{
+			name: "reads past the end",
+			req:  runRequest{Program: "4,1000,99"},
+			code: http.StatusOK,
+			want: result{Outputs: []int{0}, Steps: 2, Halted: true, MemoryHash: memoryHash([]int{4, 1000, 99})},
+		},
+		{
+			name: "negative address",
+			req:  runRequest{Program: "4,-1,99"},
+			code: http.StatusOK,
+			want: result{Outputs: []int{}, MemoryHash: memoryHash([]int{4, -1, 99}), Error: "at 0: read from negative address -1"},
+		},
+		{
+			name: "bad timeout",
+			req:  runRequest{Program: "99", Timeout: "soon"},
+			code: http.StatusBadRequest,
+			want: result{Error: `bad timeout: time: invalid duration "soon"`},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var res result
+			if code := post(t, s.URL+"/run", test.req, &res); code != test.code {
+				t.Fatalf("expected status %d; got %d with %+v", test.code, code, res)
+			}
+			if fmt.Sprintf("%+v", res) != fmt.Sprintf("%+v", test.want) {
+				t.Fatalf("expected %+v; got %+v", test.want, res)
+			}
+		})
+	}
+}
+
+func TestForeignRequests(t *testing.T) {
+	s := newTestServer(t)
+	tests := []struct {
+		host, origin string
+		code         int
+	}{
+		{"", "", http.StatusOK},
+		{"localhost:8080", "http://localhost:3000", http.StatusOK},
+		{"[::1]:8080", "http://127.0.0.1", http.StatusOK},
+		{"attacker.example:8080", "", http.StatusForbidden},
+		{"", "http://attacker.example", http.StatusForbidden},
+		{"", "null", http.StatusForbidden},
+	}
+	for _, test := range tests {
+		req, err := http.NewRequest(http.MethodPost, s.URL+"/run", strings.NewReader(`{"program": "99"}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if test.host != "" {
+			req.Host = test.host
+		}
+		if test.origin != "" {
+			req.Header.Set("Origin", test.origin)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != test.code {
+			t.Errorf("expected status %d for host %q and origin %q; got %d", test.code, test.host, test.origin, resp.StatusCode)
+		}
+	}
+}
+
+func TestRunTimeBudget(t *testing.T) {
+	s := newTestServer(t)
+	var res result
+	post(t, s.URL+"/run", runRequest{Program: "1105,1,0", Timeout: "20ms"}, &res)
+	if res.Error != "time budget exceeded" || res.Steps == 0 || res.Steps >= 1000000 {
+		t.Fatalf("expected to run out of time after some steps; got %+v", res)
+	}
+}
+
+func TestSessionHTTP(t *testing.T) {
+	s := newTestServer(t)
+	var created result
+	if code := post(t, s.URL+"/sessions", runRequest{Program: echo, HaltOnEOF: true}, &created); code != http.StatusCreated {
+		t.Fatalf("could not create session: %d %+v", code, created)
+	}
+	url := s.URL + "/sessions/" + created.ID
+
+	resp, err := http.Get(url + "/output")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	outputs := bufio.NewScanner(resp.Body)
+
+	// Stream the input, checking each output comes back before sending
+	// the next input.
+	in, w := io.Pipe()
+	posted := make(chan error, 1)
+	go func() {
+		resp, err := http.Post(url+"/input?close=true", "text/plain", in)
+		if err == nil {
+			resp.Body.Close()
+		}
+		posted <- err
+	}()
+	for _, v := range []string{"1", "2", "3"} {
+		fmt.Fprint(w, v+",")
+		if !outputs.Scan() || outputs.Text() != v {
+			t.Fatalf("expected output %s; got %q (%v)", v, outputs.Text(), outputs.Err())
+		}
+	}
+	w.Close()
+	if err := <-posted; err != nil {
+		t.Fatal(err)
+	}
+	if outputs.Scan() {
+		t.Fatalf("expected the outputs to end; got %q", outputs.Text())
+	}
+
+	resp, err = http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var res result
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		t.Fatal(err)
+	}
+	if res.Running || !res.Halted || fmt.Sprint(res.Outputs) != "[1 2 3]" || res.Steps != 9 {
+		t.Fatalf("expected the session to halt after outputting 1, 2 and 3; got %+v", res)
+	}
+
+	req, _ := http.NewRequest(http.MethodDelete, url, nil)
+	if resp, err := http.DefaultClient.Do(req); err != nil || resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("could not delete the session: %v %v", resp, err)
+	}
+	if resp, err := http.Get(url); err != nil || resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected the session to be gone: %v %v", resp, err)
+	}
+}
+
+func TestSessionWebSocket(t *testing.T) {
+	s := newTestServer(t)
+	// sum reads two values and outputs their sum.
+	var created result
+	post(t, s.URL+"/sessions", runRequest{Program: "3,11,3,12,1,11,12,11,4,11,99,0,0"}, &created)
+
+	conn, err := net.Dial("tcp", s.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	key := "dGhlIHNhbXBsZSBub25jZQ=="
+	fmt.Fprintf(conn, "GET /sessions/%s/ws HTTP/1.1\r\nHost: %s\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Key: %s\r\nSec-WebSocket-Version: 13\r\n\r\n", created.ID, s.Listener.Addr(), key)
+	r := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(r, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols || resp.Header.Get("Sec-WebSocket-Accept") != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatalf("bad handshake: %v %v", resp.Status, resp.Header)
+	}
+
+	writeClientFrame(t, conn, opText, []byte("2"))
+	writeClientFrame(t, conn, opText, []byte("3"))
+	if op, payload := readServerFrame(t, r); op != opText || string(payload) != "5" {
+		t.Fatalf("expected text message 5; got %x %q", op, payload)
+	}
+	op, payload := readServerFrame(t, r)
+	if op != opClose || binary.BigEndian.Uint16(payload) != closeNormal || string(payload[2:]) != "halted" {
+		t.Fatalf("expected the connection to close as halted; got %x %q", op, payload)
+	}
+	writeClientFrame(t, conn, opClose, payload[:2])
+}
+
+func writeClientFrame(t *testing.T, w io.Writer, op byte, payload []byte) {
+	mask := []byte{1, 2, 3, 4}
+	frame := append([]byte{0x80 | op, 0x80 | byte(len(payload))}, mask...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+	if _, err := w.Write(frame); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readServerFrame(t *testing.T, r io.Reader) (byte, []byte) {
+	head := make([]byte, 2)
+	if _, err := io.ReadFull(r, head); err != nil {
+		t.Fatal(err)
+	}
+	if head[1] >= 126 {
+		t.Fatalf("unexpected long frame")
+	}
+	payload := make([]byte, head[1])
+	if _, err := io.ReadFull(r, payload); err != nil {
+		t.Fatal(err)
+	}
+	return head[0] & 0x0f, payload
+}
+
+func TestSessionEviction(t *testing.T) {
+	s := newTestServerWith(t, 1, 50*time.Millisecond)
+	var first, second result
+	if code := post(t, s.URL+"/sessions", runRequest{Program: "99"}, &first); code != http.StatusCreated {
+		t.Fatalf("could not create session: %d %+v", code, first)
+	}
+	waitStopped(t, s.URL+"/sessions/"+first.ID)
+
+	// The first session stopped, so it makes room for the second one.
+	if code := post(t, s.URL+"/sessions", runRequest{Program: echo}, &second); code != http.StatusCreated {
+		t.Fatalf("expected the stopped session to make room; got %d %+v", code, second)
+	}
+	var res result
+	if code := post(t, s.URL+"/sessions", runRequest{Program: "99"}, &res); code != http.StatusServiceUnavailable {
+		t.Fatalf("expected too many running sessions; got %d %+v", code, res)
+	}
+
+	// Once stopped, a session is forgotten after its time to live.
+	resp, err := http.Post(s.URL+"/sessions/"+second.ID+"/input?close=true", "text/plain", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	waitStopped(t, s.URL+"/sessions/"+second.ID)
+	time.Sleep(200 * time.Millisecond)
+	if resp, err := http.Get(s.URL + "/sessions/" + second.ID); err != nil || resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected the session to expire: %v %v", resp, err)
+	}
+}
+
+// waitStopped waits for the session at url to stop running.
+func waitStopped(t *testing.T, url string) {
+	for i := 0; i < 100; i++ {
+		resp, err := http.Get(url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var res result
+		err = json.NewDecoder(resp.Body).Decode(&res)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !res.Running {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("the session at %s is still running", url)
+}
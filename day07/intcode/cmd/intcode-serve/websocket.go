@@ -0,0 +1,216 @@
+package main
+
+// This file implements the server side of the subset of the WebSocket
+// protocol (RFC 6455) used by sessions: unfragmented text messages from the
+// server, messages of any kind from the client, pings and closing.
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+const (
+	wsGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+	opText  = 0x1
+	opClose = 0x8
+	opPing  = 0x9
+	opPong  = 0xa
+
+	closeNormal          = 1000
+	closePolicyViolation = 1008
+	closeTooBig          = 1009
+	closeInternalError   = 1011
+
+	// maxMessageSize is the maximum size of a message from a client.
+	maxMessageSize = 1 << 20
+)
+
+// errClosed is returned by ReadMessage when the client closed the connection.
+var errClosed = errors.New("websocket closed")
+
+// A websocket is the server side of a WebSocket connection. Messages can be
+// written by one goroutine while another one reads them.
+type websocket struct {
+	conn net.Conn
+	r    *bufio.Reader
+
+	mu     sync.Mutex // guards writes.
+	closed bool       // set once a close frame was sent.
+}
+
+// upgrade switches the protocol of the request to WebSocket. If it fails,
+// nothing has been written to w yet.
+func upgrade(w http.ResponseWriter, r *http.Request) (*websocket, error) {
+	if !headerHas(r.Header, "Connection", "upgrade") || !headerHas(r.Header, "Upgrade", "websocket") {
+		return nil, fmt.Errorf("not a websocket handshake")
+	}
+	if v := r.Header.Get("Sec-WebSocket-Version"); v != "13" {
+		return nil, fmt.Errorf("unsupported websocket version %q", v)
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if key == "" {
+		return nil, fmt.Errorf("missing Sec-WebSocket-Key")
+	}
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		return nil, fmt.Errorf("the connection can't be upgraded")
+	}
+	conn, rw, err := hj.Hijack()
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Fprintf(rw, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %s\r\n\r\n", acceptKey(key))
+	if err := rw.Flush(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &websocket{conn: conn, r: rw.Reader}, nil
+}
+
+// acceptKey returns the answer to the key of a handshake.
+func acceptKey(key string) string {
+	h := sha1.Sum([]byte(key + wsGUID))
+	return base64.StdEncoding.EncodeToString(h[:])
+}
+
+// headerHas reports whether one of the comma separated values of the header
+// is value, ignoring case.
+func headerHas(h http.Header, name, value string) bool {
+	for _, line := range h[name] {
+		for _, v := range strings.Split(line, ",") {
+			if strings.EqualFold(strings.TrimSpace(v), value) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ReadMessage returns the payload of the next text or binary message,
+// answering pings on the way. It returns errClosed once the client closes
+// the connection.
+func (ws *websocket) ReadMessage() ([]byte, error) {
+	var msg []byte
+	for {
+		fin, op, payload, err := ws.readFrame()
+		if err != nil {
+			return nil, err
+		}
+		switch op {
+		case opClose:
+			ws.WriteClose(closeNormal, "")
+			return nil, errClosed
+		case opPing:
+			if err := ws.writeFrame(opPong, payload); err != nil {
+				return nil, err
+			}
+			continue
+		case opPong:
+			continue
+		}
+		if len(msg)+len(payload) > maxMessageSize {
+			ws.WriteClose(closeTooBig, "message too big")
+			return nil, fmt.Errorf("message too big")
+		}
+		msg = append(msg, payload...)
+		if fin {
+			return msg, nil
+		}
+	}
+}
+
+// readFrame reads a single frame, which clients must mask.
+func (ws *websocket) readFrame() (fin bool, op byte, payload []byte, err error) {
+	var head [2]byte
+	if _, err = io.ReadFull(ws.r, head[:]); err != nil {
+		return
+	}
+	fin, op = head[0]&0x80 != 0, head[0]&0x0f
+	if head[1]&0x80 == 0 {
+		err = fmt.Errorf("unmasked frame from the client")
+		return
+	}
+	size := uint64(head[1] & 0x7f)
+	switch size {
+	case 126:
+		var ext [2]byte
+		if _, err = io.ReadFull(ws.r, ext[:]); err != nil {
+			return
+		}
+		size = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err = io.ReadFull(ws.r, ext[:]); err != nil {
+			return
+		}
+		size = binary.BigEndian.Uint64(ext[:])
+	}
+	if size > maxMessageSize {
+		ws.WriteClose(closeTooBig, "message too big")
+		err = fmt.Errorf("frame of %d bytes too big", size)
+		return
+	}
+	var mask [4]byte
+	if _, err = io.ReadFull(ws.r, mask[:]); err != nil {
+		return
+	}
+	payload = make([]byte, size)
+	if _, err = io.ReadFull(ws.r, payload); err != nil {
+		return
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return
+}
+
+// WriteText sends a text message.
+func (ws *websocket) WriteText(text string) error { return ws.writeFrame(opText, []byte(text)) }
+
+// WriteClose starts closing the connection with the given status code and
+// reason, which is truncated to fit in a control frame.
+func (ws *websocket) WriteClose(code int, reason string) error {
+	if len(reason) > 123 {
+		reason = reason[:123]
+	}
+	payload := make([]byte, 2, 2+len(reason))
+	binary.BigEndian.PutUint16(payload, uint16(code))
+	return ws.writeFrame(opClose, append(payload, reason...))
+}
+
+func (ws *websocket) writeFrame(op byte, payload []byte) error {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	if ws.closed {
+		return errClosed
+	}
+	if op == opClose {
+		ws.closed = true
+	}
+
+	head := []byte{0x80 | op}
+	switch n := len(payload); {
+	case n < 126:
+		head = append(head, byte(n))
+	case n <= 0xffff:
+		head = append(head, 126, byte(n>>8), byte(n))
+	default:
+		head = append(head, 127, 0, 0, 0, 0, byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+	}
+	_, err := ws.conn.Write(append(head, payload...))
+	return err
+}
+
+// Close closes the underlying connection.
+func (ws *websocket) Close() error { return ws.conn.Close() }
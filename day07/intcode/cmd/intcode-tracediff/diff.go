@@ -0,0 +1,114 @@
+package main
+
+import "github.com/campoy/advent-of-code-2019/day07/intcode"
+
+type segmentKind int
+
+const (
+	// identical steps ran the same instructions with the same effects.
+	identical segmentKind = iota
+	// changed steps ran the same instructions, but read or wrote different
+	// values.
+	changed
+	// diverged steps ran different instructions. The two sides of a diverged
+	// segment may have different lengths, and one of them may be empty.
+	diverged
+)
+
+func (k segmentKind) String() string {
+	switch k {
+	case identical:
+		return "identical"
+	case changed:
+		return "changed"
+	}
+	return "diverged"
+}
+
+// A segment pairs the steps a[AFrom:ATo] of a trace with the steps b[BFrom:BTo]
+// of another one.
+type segment struct {
+	Kind       segmentKind
+	AFrom, ATo int
+	BFrom, BTo int
+}
+
+// align splits two traces into segments. Steps running the same instructions
+// are paired, and after the traces diverge they are realigned on the closest
+// steps, skipping at most window steps on each side, from which anchor steps
+// in a row run the same instructions.
+func align(a, b []intcode.TraceStep, window, anchor int) []segment {
+	var segs []segment
+	add := func(kind segmentKind, i, j, ni, nj int) {
+		if n := len(segs); n > 0 && segs[n-1].Kind == kind {
+			segs[n-1].ATo, segs[n-1].BTo = ni, nj
+			return
+		}
+		segs = append(segs, segment{kind, i, ni, j, nj})
+	}
+
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i].Equal(b[j]):
+			add(identical, i, j, i+1, j+1)
+			i, j = i+1, j+1
+		case a[i].SameFlow(b[j]):
+			add(changed, i, j, i+1, j+1)
+			i, j = i+1, j+1
+		default:
+			ni, nj, ok := realign(a, b, i, j, window, anchor)
+			if !ok {
+				ni, nj = len(a), len(b)
+			}
+			add(diverged, i, j, ni, nj)
+			i, j = ni, nj
+		}
+	}
+	if i < len(a) || j < len(b) {
+		add(diverged, i, j, len(a), len(b))
+	}
+	return segs
+}
+
+// realign finds the steps closest to a[i] and b[j] from which the two traces
+// run the same instructions again.
+func realign(a, b []intcode.TraceStep, i, j, window, anchor int) (int, int, bool) {
+	for d := 1; d <= 2*window; d++ {
+		for di := 0; di <= d; di++ {
+			dj := d - di
+			if di > window || dj > window || i+di >= len(a) || j+dj >= len(b) {
+				continue
+			}
+			if sameFlow(a[i+di:], b[j+dj:], anchor) {
+				return i + di, j + dj, true
+			}
+		}
+	}
+	return 0, 0, false
+}
+
+// sameFlow reports whether the first n steps of a and b, or as many as they
+// both have, run the same instructions.
+func sameFlow(a, b []intcode.TraceStep, n int) bool {
+	for k := 0; k < n && k < len(a) && k < len(b); k++ {
+		if !a[k].SameFlow(b[k]) {
+			return false
+		}
+	}
+	return true
+}
+
+// memoryAt returns the memory of a traced computer before its n-th step.
+func memoryAt(t *intcode.Trace, n int) []int {
+	mem := append([]int(nil), t.Memory...)
+	for _, step := range t.Steps[:n] {
+		for _, w := range step.Writes {
+			for w.Addr >= len(mem) {
+				mem = append(mem, 0)
+			}
+			mem[w.Addr] = w.Value
+		}
+	}
+	return mem
+}
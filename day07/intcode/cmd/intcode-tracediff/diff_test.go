@@ -0,0 +1,49 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/campoy/advent-of-code-2019/day07/intcode"
+)
+
+// steps returns a trace running the instructions at the given addresses.
+func steps(pcs ...int) []intcode.TraceStep {
+	var t []intcode.TraceStep
+	for i, pc := range pcs {
+		t = append(t, intcode.TraceStep{Step: i, PC: pc, Inst: "NOP"})
+	}
+	return t
+}
+
+func TestAlign(t *testing.T) {
+	changedB := steps(0, 1, 2, 3)
+	changedB[1].Value = 7
+
+	tests := []struct {
+		name string
+		a, b []intcode.TraceStep
+		want []segment
+	}{
+		{"identical", steps(0, 1, 2), steps(0, 1, 2), []segment{{identical, 0, 3, 0, 3}}},
+		{"changed", steps(0, 1, 2, 3), changedB, []segment{
+			{identical, 0, 1, 0, 1}, {changed, 1, 2, 1, 2}, {identical, 2, 4, 2, 4},
+		}},
+		{"detour", steps(0, 1, 5, 6, 7, 2, 3, 4, 8), steps(0, 1, 2, 3, 4, 8), []segment{
+			{identical, 0, 2, 0, 2}, {diverged, 2, 5, 2, 2}, {identical, 5, 9, 2, 6},
+		}},
+		{"replaced", steps(0, 1, 5, 2, 3, 4, 8), steps(0, 1, 6, 7, 2, 3, 4, 8), []segment{
+			{identical, 0, 2, 0, 2}, {diverged, 2, 3, 2, 4}, {identical, 3, 7, 4, 8},
+		}},
+		{"longer", steps(0, 1), steps(0, 1, 2), []segment{{identical, 0, 2, 0, 2}, {diverged, 2, 2, 2, 3}}},
+		{"unaligned", steps(0, 1, 2, 3), steps(0, 4, 5, 6), []segment{{identical, 0, 1, 0, 1}, {diverged, 1, 4, 1, 4}}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got := align(tc.a, tc.b, 10, 3)
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("expected %v; got %v", tc.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,131 @@
+// Command intcode-tracediff compares two traces written by intcode -trace,
+// such as the runs of a program with different inputs, or of a program and
+// its patched version.
+//
+//	intcode-tracediff [-window n] [-anchor n] a.jsonl b.jsonl
+//
+// It reports the first step where the traces differ along with the memory
+// cells holding different values at that point, and then how the rest of
+// the traces align: runs of identical steps, of steps running the same
+// instructions on different values, and of diverged steps, after which the
+// traces are realigned where possible. It exits with status 1 if the traces
+// differ.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/campoy/advent-of-code-2019/day07/intcode"
+)
+
+func main() {
+	window := flag.Int("window", 1000, "maximum number of steps skipped on each side to realign the traces")
+	anchor := flag.Int("anchor", 4, "number of steps running the same instructions needed to realign the traces")
+	maxCells := flag.Int("cells", 20, "maximum number of differing memory cells shown")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [flags] a.jsonl b.jsonl\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 2 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	a, err := readTrace(flag.Arg(0))
+	if err != nil {
+		log.Fatal(err)
+	}
+	b, err := readTrace(flag.Arg(1))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if !report(os.Stdout, a, b, *window, *anchor, *maxCells) {
+		os.Exit(1)
+	}
+}
+
+func readTrace(path string) (*intcode.Trace, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	t, err := intcode.ReadTrace(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return t, nil
+}
+
+// report writes the differences between a and b to w, and reports whether
+// the traces are identical.
+func report(w io.Writer, a, b *intcode.Trace, window, anchor, maxCells int) bool {
+	segs := align(a.Steps, b.Steps, window, anchor)
+	first := -1
+	for i, seg := range segs {
+		if seg.Kind != identical {
+			first = i
+			break
+		}
+	}
+	initial := intcode.DiffMemory(a.Memory, b.Memory)
+	if first < 0 && len(initial) == 0 {
+		fmt.Fprintf(w, "traces are identical (%d steps)\n", len(a.Steps))
+		return true
+	}
+
+	if first >= 0 {
+		seg := segs[first]
+		fmt.Fprintf(w, "first difference at step %d of a and step %d of b:\n", seg.AFrom, seg.BFrom)
+		fmt.Fprintf(w, "  a: %s\n", stepText(a.Steps, seg.AFrom))
+		fmt.Fprintf(w, "  b: %s\n", stepText(b.Steps, seg.BFrom))
+		writeCells(w, "memory differing before that step:", intcode.DiffMemory(memoryAt(a, seg.AFrom), memoryAt(b, seg.BFrom)), maxCells)
+	} else {
+		fmt.Fprintln(w, "traces run the same steps from different memory")
+		writeCells(w, "memory differing at the start:", initial, maxCells)
+	}
+
+	fmt.Fprintln(w, "alignment:")
+	for _, seg := range segs {
+		fmt.Fprintf(w, "  %-9s a %-12s b %s\n", seg.Kind, span(seg.AFrom, seg.ATo), span(seg.BFrom, seg.BTo))
+	}
+	writeCells(w, "memory differing at the end:", intcode.DiffMemory(memoryAt(a, len(a.Steps)), memoryAt(b, len(b.Steps))), maxCells)
+	return false
+}
+
+func stepText(steps []intcode.TraceStep, i int) string {
+	if i >= len(steps) {
+		return "end of trace"
+	}
+	return steps[i].String()
+}
+
+func span(from, to int) string {
+	switch to - from {
+	case 0:
+		return "(none)"
+	case 1:
+		return fmt.Sprint(from)
+	}
+	return fmt.Sprintf("%d-%d", from, to-1)
+}
+
+func writeCells(w io.Writer, title string, diffs []intcode.CellDiff, max int) {
+	if len(diffs) == 0 {
+		return
+	}
+	fmt.Fprintln(w, title)
+	for i, d := range diffs {
+		if i == max {
+			fmt.Fprintf(w, "  ... and %d more\n", len(diffs)-max)
+			break
+		}
+		fmt.Fprintf(w, "  %5d: %d != %d\n", d.Addr, d.A, d.B)
+	}
+}
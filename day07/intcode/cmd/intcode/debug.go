@@ -0,0 +1,359 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/campoy/advent-of-code-2019/day07/intcode"
+)
+
+const debugHelp = `commands:
+  step [n]            (s)   run the next n instructions
+  continue            (c)   run until a breakpoint, an input or the end
+  reverse-step [n]    (rs)  undo the last n instructions
+  reverse-continue    (rc)  go back until a breakpoint or the start of history
+  break addr [if e]   (b)   stop before running the instruction at addr
+  break if e          (b)   stop as soon as the expression e is true
+  watch a[-b] [r|w]   (w)   stop on reads or writes of memory from a to b
+  delete id           (d)   remove a breakpoint or watchpoint
+  breakpoints         (bl)  list the breakpoints and watchpoints
+  input v...          (i)   give values to the program
+  print addr [n]      (p)   show n memory cells from addr
+  list [addr] [n]     (l)   disassemble n instructions from addr
+  dump [rows]         (x)   show memory around the pc, marking the cells
+                            changed since the last dump
+  regs                (r)   show the registers
+  quit                (q)   leave the debugger`
+
+// A debugger runs commands read from an interactive session on a computer.
+// Addresses can be given by their symbol, if the image of the program has
+// symbols.
+type debugger struct {
+	c  *intcode.Computer
+	im *intcode.Image
+	w  io.Writer
+
+	// dumped is a snapshot of the computer taken by the last dump.
+	dumped *intcode.Computer
+}
+
+func newDebugger(c *intcode.Computer, im *intcode.Image, w io.Writer) *debugger {
+	return &debugger{c: c, im: im, w: w, dumped: c.Snapshot()}
+}
+
+// repl reads commands from r, one per line, until quit or the end of r.
+func (d *debugger) repl(r io.Reader) error {
+	s := bufio.NewScanner(r)
+	d.where()
+	fmt.Fprint(d.w, "(icdb) ")
+	for s.Scan() {
+		args := strings.Fields(s.Text())
+		if len(args) > 0 {
+			if args[0] == "quit" || args[0] == "q" {
+				return nil
+			}
+			if err := d.exec(args[0], args[1:]); err != nil {
+				fmt.Fprintln(d.w, "error:", err)
+			}
+		}
+		fmt.Fprint(d.w, "(icdb) ")
+	}
+	return s.Err()
+}
+
+func (d *debugger) exec(cmd string, args []string) error {
+	switch cmd {
+	case "step", "s":
+		n, err := optInt(args, 0, 1)
+		if err != nil {
+			return err
+		}
+		for i := 0; i < n; i++ {
+			if stop, err := d.step(); stop || err != nil {
+				d.where()
+				return err
+			}
+		}
+		d.where()
+	case "continue", "c":
+		for {
+			if stop, err := d.step(); stop || err != nil {
+				d.where()
+				return err
+			}
+			id, err := d.c.AtBreakpoint()
+			if err != nil {
+				return err
+			}
+			if id != 0 {
+				fmt.Fprintf(d.w, "breakpoint %d at %d\n", id, d.c.PC())
+				break
+			}
+		}
+		d.where()
+	case "reverse-step", "rs":
+		n, err := optInt(args, 0, 1)
+		if err != nil {
+			return err
+		}
+		for i := 0; i < n; i++ {
+			if _, err := d.c.StepBack(); err != nil {
+				d.where()
+				return err
+			}
+		}
+		d.where()
+	case "reverse-continue", "rc":
+		ev, err := d.c.ReverseContinue()
+		if err == nil {
+			d.stopped(ev)
+		}
+		d.where()
+		return err
+	case "break", "b":
+		return d.addBreakpoint(args)
+	case "watch", "w":
+		return d.addWatchpoint(args)
+	case "delete", "d":
+		id, err := optInt(args, 0, 0)
+		if err != nil {
+			return err
+		}
+		if !d.c.Delete(id) {
+			return fmt.Errorf("no breakpoint with id %d", id)
+		}
+	case "breakpoints", "bl":
+		for _, b := range d.c.Breakpoints() {
+			fmt.Fprintln(d.w, b)
+		}
+	case "input", "i":
+		for _, arg := range args {
+			v, err := strconv.Atoi(arg)
+			if err != nil {
+				return err
+			}
+			d.c.Provide(v)
+		}
+	case "print", "p":
+		addr, err := d.memAddr(args, 0, d.c.PC())
+		if err != nil {
+			return err
+		}
+		n, err := optInt(args, 1, 1)
+		if err != nil {
+			return err
+		}
+		mem := d.c.Memory()
+		for i := addr; i < addr+n && i < len(mem); i++ {
+			if name := d.im.Symbols[i]; name != "" {
+				fmt.Fprintf(d.w, "%5d <%s>: %d\n", i, name, mem[i])
+			} else {
+				fmt.Fprintf(d.w, "%5d: %d\n", i, mem[i])
+			}
+		}
+	case "list", "l":
+		addr, err := d.memAddr(args, 0, d.c.PC())
+		if err != nil {
+			return err
+		}
+		n, err := optInt(args, 1, 10)
+		if err != nil {
+			return err
+		}
+		d.list(addr, n)
+	case "dump", "x":
+		rows, err := optInt(args, 0, 5)
+		if err != nil {
+			return err
+		}
+		opts := intcode.DumpOptions{Window: rows, Since: d.dumped, Symbols: d.im.Symbols}
+		if err := d.c.Dump(d.w, opts); err != nil {
+			return err
+		}
+		d.dumped = d.c.Snapshot()
+	case "regs", "r":
+		fmt.Fprintf(d.w, "pc: %d\nrb: %d\nsteps: %d\nhalted: %v\n",
+			d.c.PC(), d.c.RelativeBase(), d.c.Steps(), d.c.Halted())
+	case "help", "h":
+		fmt.Fprintln(d.w, debugHelp)
+	default:
+		return fmt.Errorf("unknown command %q, try help", cmd)
+	}
+	return nil
+}
+
+// step runs one instruction, and reports whether the program can't go on
+// without the user doing something first.
+func (d *debugger) step() (bool, error) {
+	ev, err := d.c.Step()
+	if err != nil {
+		return true, err
+	}
+	switch ev.Kind {
+	case intcode.Output:
+		fmt.Fprintln(d.w, "output:", ev.Value)
+	}
+	if len(ev.Watch) > 0 {
+		d.stopped(ev)
+		return true, nil
+	}
+	switch ev.Kind {
+	case intcode.NeedsInput:
+		fmt.Fprintln(d.w, "waiting for input")
+		return true, nil
+	case intcode.Halted:
+		fmt.Fprintln(d.w, "halted")
+		return true, nil
+	}
+	return false, nil
+}
+
+func (d *debugger) isBreakpoint(addr int) bool {
+	for _, b := range d.c.Breakpoints() {
+		if b.Access == 0 && b.Addr == addr {
+			return true
+		}
+	}
+	return false
+}
+
+// where shows the instruction about to run.
+func (d *debugger) where() { d.list(d.c.PC(), 1) }
+
+func (d *debugger) list(addr, n int) {
+	mem := d.c.Memory()
+	for i := 0; i < n && addr >= 0 && addr < len(mem); i++ {
+		marker := "  "
+		if addr == d.c.PC() {
+			marker = "=>"
+		}
+		if d.isBreakpoint(addr) {
+			marker = "*" + marker[1:]
+		}
+		text, next, err := d.im.Symbols.Disassemble(mem, addr)
+		if err != nil {
+			text = fmt.Sprintf("DATA %d", mem[addr])
+			next = addr + 1
+		}
+		if name := d.im.Symbols[addr]; name != "" {
+			fmt.Fprintf(d.w, "%s:\n", name)
+		}
+		if src, ok := d.im.SourceOf(addr); ok {
+			text += "  ; " + src.String()
+		}
+		fmt.Fprintf(d.w, "%s %5d: %s\n", marker, addr, text)
+		addr = next
+	}
+}
+
+// stopped explains why a continue or reverse-continue stopped at ev.
+func (d *debugger) stopped(ev intcode.Event) {
+	for _, hit := range ev.Watch {
+		fmt.Fprintf(d.w, "%v by instruction at %d\n", hit, ev.PC)
+	}
+	if ev.Breakpoint != 0 {
+		fmt.Fprintf(d.w, "breakpoint %d at %d\n", ev.Breakpoint, d.c.PC())
+	}
+}
+
+// addBreakpoint parses the arguments "addr", "addr if expr" or "if expr".
+func (d *debugger) addBreakpoint(args []string) error {
+	addr := intcode.AnyAddress
+	if len(args) > 0 && args[0] != "if" {
+		v, err := d.addr(args[0])
+		if err != nil {
+			return err
+		}
+		addr, args = v, args[1:]
+	}
+
+	var cond *intcode.Condition
+	if len(args) > 0 {
+		if args[0] != "if" || len(args) == 1 {
+			return fmt.Errorf("expected: break [addr] [if expr]")
+		}
+		var err error
+		cond, err = intcode.ParseCondition(strings.Join(args[1:], " "))
+		if err != nil {
+			return err
+		}
+	}
+	if addr == intcode.AnyAddress && cond == nil {
+		return fmt.Errorf("break needs an address or a condition")
+	}
+	fmt.Fprintf(d.w, "breakpoint %d\n", d.c.Break(addr, cond))
+	return nil
+}
+
+// addWatchpoint parses the arguments "addr" or "from-to", followed by r, w
+// or rw.
+func (d *debugger) addWatchpoint(args []string) error {
+	if len(args) == 0 || len(args) > 2 {
+		return fmt.Errorf("expected: watch addr[-addr] [r|w|rw]")
+	}
+	bounds := strings.SplitN(args[0], "-", 2)
+	from, err := d.addr(bounds[0])
+	if err != nil {
+		return err
+	}
+	to := from
+	if len(bounds) == 2 {
+		if to, err = d.addr(bounds[1]); err != nil {
+			return err
+		}
+	}
+
+	access := intcode.WriteAccess
+	if len(args) == 2 {
+		switch args[1] {
+		case "r":
+			access = intcode.ReadAccess
+		case "w":
+			access = intcode.WriteAccess
+		case "rw":
+			access = intcode.ReadAccess | intcode.WriteAccess
+		default:
+			return fmt.Errorf("unknown access %q, expected r, w or rw", args[1])
+		}
+	}
+	fmt.Fprintf(d.w, "watchpoint %d\n", d.c.Watch(from, to, access))
+	return nil
+}
+
+// addr parses an address, given either as a number or as a symbol.
+func (d *debugger) addr(arg string) (int, error) {
+	for addr, name := range d.im.Symbols {
+		if name == arg {
+			return addr, nil
+		}
+	}
+	return strconv.Atoi(arg)
+}
+
+// optAddr parses the i-th argument as an address, if present.
+func (d *debugger) optAddr(args []string, i, def int) (int, error) {
+	if i >= len(args) {
+		return def, nil
+	}
+	return d.addr(args[i])
+}
+
+// memAddr is optAddr for the commands showing the memory at the address.
+func (d *debugger) memAddr(args []string, i, def int) (int, error) {
+	addr, err := d.optAddr(args, i, def)
+	if err == nil && addr < 0 {
+		err = fmt.Errorf("negative address %d", addr)
+	}
+	return addr, err
+}
+
+// optInt parses the i-th argument as an integer, if present.
+func optInt(args []string, i, def int) (int, error) {
+	if i >= len(args) {
+		return def, nil
+	}
+	return strconv.Atoi(args[i])
+}
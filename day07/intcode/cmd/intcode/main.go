@@ -0,0 +1,284 @@
+// Command intcode runs an Intcode program, debugs it interactively, or shows
+// it running in a full-screen terminal view with -tui.
+//
+// Programs are read either as text or as binary images, which may hold
+// symbols, a source map and named sets of inputs, and can be converted from
+// one format to the other with -convert.
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"os"
+
+	"github.com/campoy/advent-of-code-2019/day07/intcode"
+)
+
+func main() {
+	inputs := flag.String("in", "", "comma separated inputs, read from stdin once consumed")
+	debug := flag.Bool("debug", false, "start the interactive debugger")
+	ui := flag.Bool("tui", false, "watch the program run in a full-screen terminal view")
+	speed := flag.Int("speed", 100, "instructions run per second by -tui at first, or as many as possible if negative")
+	history := flag.Int("history", 1<<20, "memory cells used to record history for reverse debugging")
+	coverprofile := flag.String("coverprofile", "", "write a coverage profile to this file")
+	record := flag.String("record", "", "write the inputs and outputs of the run to this replay file")
+	trace := flag.String("trace", "", "write a JSON Lines trace of every instruction to this file")
+	replay := flag.String("replay", "", "check the program against a replay file instead of running it")
+	node := flag.Int("node", 0, "computer of the replay file to check, with -replay")
+	checked := flag.Bool("checked", false, "fail when an addition or multiplication overflows")
+	bigCells := flag.Bool("big", false, "run a text program with cells of arbitrary precision, without any debugging option")
+	entry := flag.String("entry", "", "start with the named inputs stored in the program")
+	verify := flag.Bool("verify", false, "check the program for problems before running it, and stop if there are any")
+	convert := flag.String("convert", "", "write the program to stdout in the given format, text or binary, instead of running it")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [flags] program.txt\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	text, err := ioutil.ReadFile(flag.Arg(0))
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *bigCells {
+		if *debug || *ui || *coverprofile != "" || *record != "" || *trace != "" || *replay != "" || *checked || *verify {
+			log.Fatal("-big can't be combined with -debug, -tui, -coverprofile, -record, -trace, -replay, -checked or -verify")
+		}
+		if err := runBig(string(text), *inputs, os.Stdin, os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if *ui && (*debug || *trace != "") {
+		log.Fatal("-tui can't be combined with -debug or -trace")
+	}
+	im, err := intcode.Load(text)
+	if err != nil {
+		log.Fatal(err)
+	}
+	program := im.Program
+
+	switch *convert {
+	case "":
+	case "text":
+		if err := im.WriteText(os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	case "binary":
+		if err := intcode.EncodeImage(os.Stdout, im); err != nil {
+			log.Fatal(err)
+		}
+		return
+	default:
+		log.Fatalf("unknown format %q, expected text or binary", *convert)
+	}
+
+	if *verify {
+		if warnings := intcode.Verify(program); len(warnings) > 0 {
+			for _, w := range warnings {
+				addr := fmt.Sprint(w.Addr)
+				if name := im.Symbols[w.Addr]; name != "" {
+					addr += " <" + name + ">"
+				}
+				fmt.Fprintf(os.Stderr, "%s: %v: %s\n", addr, w.Kind, w.Msg)
+			}
+			os.Exit(1)
+		}
+	}
+
+	if *replay != "" {
+		if err := replayFile(*replay, *node, program); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	c := intcode.NewComputer(program, nil, nil)
+	c.CheckOverflow = *checked
+	if *entry != "" {
+		values, ok := im.Input(*entry)
+		if !ok {
+			log.Fatalf("the program has no inputs named %q", *entry)
+		}
+		c.Provide(values...)
+	}
+	if *inputs != "" {
+		values, err := intcode.Parse(*inputs)
+		if err != nil {
+			log.Fatal(err)
+		}
+		c.Provide(values...)
+	}
+
+	var cov *intcode.Coverage
+	if *coverprofile != "" {
+		cov = intcode.NewCoverage(len(program))
+		c.Cover(cov)
+	}
+
+	var rec *intcode.Recording
+	if *record != "" {
+		rec = new(intcode.Recording)
+		c.Record(rec)
+	}
+
+	var traceFile *os.File
+	var traceBuf *bufio.Writer
+	if *trace != "" {
+		if traceFile, err = os.Create(*trace); err != nil {
+			log.Fatal(err)
+		}
+		traceBuf = bufio.NewWriter(traceFile)
+		if err := c.Trace(traceBuf); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if *debug {
+		c.RecordHistory(*history)
+		err = newDebugger(c, im, os.Stdout).repl(os.Stdin)
+	} else if *ui {
+		err = runTUI(c, im, *speed)
+	} else {
+		err = run(c, os.Stdin, os.Stdout)
+	}
+
+	// The trace, coverage and recording are written even if the program
+	// failed.
+	if traceFile != nil {
+		if err := traceBuf.Flush(); err != nil {
+			log.Fatal(err)
+		}
+		if err := traceFile.Close(); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if cov != nil {
+		if err := writeCoverage(*coverprofile, cov); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if rec != nil {
+		if err := writeRecording(*record, rec); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func writeCoverage(path string, cov *intcode.Coverage) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := cov.WriteProfile(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func writeRecording(path string, rec *intcode.Recording) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := intcode.WriteRecordings(f, []*intcode.Recording{rec}); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// replayFile replays the execution of the given computer of a replay file.
+func replayFile(path string, node int, program []int) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	recs, err := intcode.ReadRecordings(f)
+	if err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	if node < 0 || node >= len(recs) {
+		return fmt.Errorf("%s has no computer %d", path, node)
+	}
+	if err := recs[node].Replay(program); err != nil {
+		return err
+	}
+	fmt.Printf("replay of computer %d matches its %d records\n", node, len(recs[node].Records))
+	return nil
+}
+
+// run runs the program until it halts, reading any missing input from r and
+// writing every output to w on its own line.
+func run(c *intcode.Computer, r io.Reader, w io.Writer) error {
+	in := bufio.NewReader(r)
+	for {
+		ev, err := c.Step()
+		if err != nil {
+			return fmt.Errorf("at %d: %v", ev.PC, err)
+		}
+		switch ev.Kind {
+		case intcode.Halted:
+			return nil
+		case intcode.Output:
+			fmt.Fprintln(w, ev.Value)
+		case intcode.NeedsInput:
+			var v int
+			if _, err := fmt.Fscan(in, &v); err != nil {
+				return fmt.Errorf("could not read input: %v", err)
+			}
+			c.Provide(v)
+		}
+	}
+}
+
+// runBig is like run, for a program with cells of arbitrary precision.
+func runBig(text, inputs string, r io.Reader, w io.Writer) error {
+	program, err := intcode.ParseBig(text)
+	if err != nil {
+		return err
+	}
+	c := intcode.NewBigComputer(program, nil, nil)
+	if inputs != "" {
+		values, err := intcode.ParseBig(inputs)
+		if err != nil {
+			return err
+		}
+		c.Provide(values...)
+	}
+
+	in := bufio.NewReader(r)
+	for {
+		ev, err := c.Step()
+		if err != nil {
+			return fmt.Errorf("at %d: %v", ev.PC, err)
+		}
+		switch ev.Kind {
+		case intcode.Halted:
+			return nil
+		case intcode.Output:
+			fmt.Fprintln(w, ev.Value)
+		case intcode.NeedsInput:
+			v := new(big.Int)
+			if _, err := fmt.Fscan(in, v); err != nil {
+				return fmt.Errorf("could not read input: %v", err)
+			}
+			c.Provide(v)
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/campoy/advent-of-code-2019/day07/intcode"
+	"github.com/campoy/advent-of-code-2019/day07/intcode/tui"
+)
+
+// runTUI shows the computer running in the terminal, which is put in raw
+// mode with stty for the duration of the run.
+func runTUI(c *intcode.Computer, im *intcode.Image, speed int) error {
+	state, err := stty("-g")
+	if err != nil {
+		return fmt.Errorf("-tui needs a terminal: %v", err)
+	}
+	opts := tui.Options{Speed: speed, Symbols: im.Symbols}
+	if size, err := stty("size"); err == nil {
+		fmt.Sscan(size, &opts.Height, &opts.Width)
+	}
+	if _, err := stty("raw", "-echo"); err != nil {
+		return err
+	}
+	defer stty(state)
+	return tui.Run(c, os.Stdin, os.Stdout, opts)
+}
+
+func stty(args ...string) (string, error) {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = os.Stdin
+	out, err := cmd.Output()
+	return strings.TrimSpace(string(out)), err
+}
@@ -0,0 +1,248 @@
+package intcode
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// A Condition is a boolean expression over the state of a computer, such as
+// "mem[1032] > 10 && pc == 44", used by conditional breakpoints.
+//
+// Expressions operate on integers, with booleans being 0 and 1 as in Intcode
+// itself. They can use integer literals, the registers pc and rb, memory
+// cells as mem[expr], parentheses, the unary operators - and !, and the
+// binary operators * / % + - < <= > >= == != && ||, with the same
+// precedence as in Go.
+type Condition struct {
+	text string
+	expr expr
+}
+
+// ParseCondition parses the text of a condition.
+func ParseCondition(text string) (*Condition, error) {
+	p := &condParser{text: text}
+	p.next()
+	e, err := p.parseBinary(0)
+	if err != nil {
+		return nil, err
+	}
+	if p.tok != "" {
+		return nil, fmt.Errorf("unexpected %q at %d in %q", p.tok, p.pos, text)
+	}
+	return &Condition{text: strings.TrimSpace(text), expr: e}, nil
+}
+
+func (c *Condition) String() string { return c.text }
+
+// Eval reports whether the condition holds for the given computer. Memory
+// reads 0 past its end, as it does for programs, and Eval fails if the
+// condition reads a negative address or divides by zero.
+func (c *Condition) Eval(comp *Computer) (bool, error) {
+	v, err := c.expr(comp)
+	return v != 0, err
+}
+
+// Value evaluates the condition as an integer expression.
+func (c *Condition) Value(comp *Computer) (int, error) { return c.expr(comp) }
+
+type expr func(c *Computer) (int, error)
+
+// binaryOps lists the binary operators, from lowest to highest precedence.
+var binaryOps = [][]string{
+	{"||"},
+	{"&&"},
+	{"==", "!=", "<", "<=", ">", ">="},
+	{"+", "-"},
+	{"*", "/", "%"},
+}
+
+type condParser struct {
+	text string
+	pos  int    // position after the current token.
+	tok  string // current token, empty at the end of the text.
+}
+
+var twoCharOps = []string{"||", "&&", "==", "!=", "<=", ">="}
+
+func (p *condParser) next() {
+	for p.pos < len(p.text) && p.text[p.pos] == ' ' {
+		p.pos++
+	}
+	start := p.pos
+	switch {
+	case p.pos == len(p.text):
+	case unicode.IsDigit(rune(p.text[p.pos])):
+		for p.pos < len(p.text) && unicode.IsDigit(rune(p.text[p.pos])) {
+			p.pos++
+		}
+	case unicode.IsLetter(rune(p.text[p.pos])):
+		for p.pos < len(p.text) && unicode.IsLetter(rune(p.text[p.pos])) {
+			p.pos++
+		}
+	case p.pos+1 < len(p.text) && contains(twoCharOps, p.text[p.pos:p.pos+2]):
+		p.pos += 2
+	default:
+		p.pos++
+	}
+	p.tok = p.text[start:p.pos]
+}
+
+func (p *condParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("%s at %d in %q", fmt.Sprintf(format, args...), p.pos, p.text)
+}
+
+func (p *condParser) parseBinary(level int) (expr, error) {
+	if level == len(binaryOps) {
+		return p.parseUnary()
+	}
+	left, err := p.parseBinary(level + 1)
+	if err != nil {
+		return nil, err
+	}
+	for contains(binaryOps[level], p.tok) {
+		op := p.tok
+		p.next()
+		right, err := p.parseBinary(level + 1)
+		if err != nil {
+			return nil, err
+		}
+		left = binaryExpr(op, left, right)
+	}
+	return left, nil
+}
+
+func (p *condParser) parseUnary() (expr, error) {
+	switch p.tok {
+	case "-", "!":
+		op := p.tok
+		p.next()
+		e, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		if op == "-" {
+			return func(c *Computer) (int, error) {
+				v, err := e(c)
+				return -v, err
+			}, nil
+		}
+		return func(c *Computer) (int, error) {
+			v, err := e(c)
+			return boolToInt(v == 0), err
+		}, nil
+	case "(":
+		p.next()
+		e, err := p.parseBinary(0)
+		if err != nil {
+			return nil, err
+		}
+		if p.tok != ")" {
+			return nil, p.errorf("expected )")
+		}
+		p.next()
+		return e, nil
+	case "pc":
+		p.next()
+		return func(c *Computer) (int, error) { return c.nextInst, nil }, nil
+	case "rb":
+		p.next()
+		return func(c *Computer) (int, error) { return c.relBase, nil }, nil
+	case "mem":
+		p.next()
+		if p.tok != "[" {
+			return nil, p.errorf("expected [")
+		}
+		p.next()
+		addr, err := p.parseBinary(0)
+		if err != nil {
+			return nil, err
+		}
+		if p.tok != "]" {
+			return nil, p.errorf("expected ]")
+		}
+		p.next()
+		return func(c *Computer) (int, error) {
+			a, err := addr(c)
+			if err != nil {
+				return 0, err
+			}
+			if a < 0 {
+				return 0, fmt.Errorf("negative address %d", a)
+			}
+			return c.cell(a), nil
+		}, nil
+	case "":
+		return nil, p.errorf("unexpected end of condition")
+	}
+
+	v, err := strconv.Atoi(p.tok)
+	if err != nil {
+		return nil, p.errorf("unexpected %q", p.tok)
+	}
+	p.next()
+	return func(*Computer) (int, error) { return v, nil }, nil
+}
+
+func binaryExpr(op string, left, right expr) expr {
+	return func(c *Computer) (int, error) {
+		a, err := left(c)
+		if err != nil {
+			return 0, err
+		}
+		// && and || only evaluate their right operand when needed.
+		if (op == "&&" && a == 0) || (op == "||" && a != 0) {
+			return boolToInt(a != 0), nil
+		}
+		b, err := right(c)
+		if err != nil {
+			return 0, err
+		}
+		switch op {
+		case "||", "&&":
+			return boolToInt(b != 0), nil
+		case "==":
+			return boolToInt(a == b), nil
+		case "!=":
+			return boolToInt(a != b), nil
+		case "<":
+			return boolToInt(a < b), nil
+		case "<=":
+			return boolToInt(a <= b), nil
+		case ">":
+			return boolToInt(a > b), nil
+		case ">=":
+			return boolToInt(a >= b), nil
+		case "+":
+			return a + b, nil
+		case "-":
+			return a - b, nil
+		case "*":
+			return a * b, nil
+		}
+		if b == 0 {
+			return 0, fmt.Errorf("division by zero")
+		}
+		if op == "/" {
+			return a / b, nil
+		}
+		return a % b, nil
+	}
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
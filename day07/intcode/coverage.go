@@ -0,0 +1,168 @@
+package intcode
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+)
+
+// Coverage counts, for every memory cell of a program, how many times an
+// instruction starting there was executed, and how many times it was read
+// or written as data. A Coverage can be shared by several computers running
+// the same program, as long as they are not run concurrently.
+type Coverage struct {
+	Executed []int
+	Reads    []int
+	Writes   []int
+}
+
+// NewCoverage returns an empty coverage for a program of the given size.
+func NewCoverage(size int) *Coverage {
+	return &Coverage{
+		Executed: make([]int, size),
+		Reads:    make([]int, size),
+		Writes:   make([]int, size),
+	}
+}
+
+// Cover makes the computer record the instructions it runs and the memory
+// it accesses into cov, which must be as large as its program. Accesses to
+// the memory grown past the program aren't counted.
+func (c *Computer) Cover(cov *Coverage) { c.cov = cov }
+
+// Merge adds the counts of other, for the same program, into cov.
+func (cov *Coverage) Merge(other *Coverage) error {
+	if len(other.Executed) != len(cov.Executed) {
+		return fmt.Errorf("can't merge coverage of %d cells into %d cells", len(other.Executed), len(cov.Executed))
+	}
+	for i := range cov.Executed {
+		cov.Executed[i] += other.Executed[i]
+		cov.Reads[i] += other.Reads[i]
+		cov.Writes[i] += other.Writes[i]
+	}
+	return nil
+}
+
+const coverageHeader = "intcode coverage v1"
+
+// WriteProfile writes the coverage as a profile, which has a header line with
+// the number of cells, followed by a line with the address and the executed,
+// read and written counts for every cell that was used.
+func (cov *Coverage) WriteProfile(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "%s %d\n", coverageHeader, len(cov.Executed))
+	for i := range cov.Executed {
+		if cov.Executed[i]+cov.Reads[i]+cov.Writes[i] > 0 {
+			fmt.Fprintf(bw, "%d %d %d %d\n", i, cov.Executed[i], cov.Reads[i], cov.Writes[i])
+		}
+	}
+	return bw.Flush()
+}
+
+// ReadCoverage reads a profile written by WriteProfile.
+func ReadCoverage(r io.Reader) (*Coverage, error) {
+	s := bufio.NewScanner(r)
+	if !s.Scan() {
+		return nil, fmt.Errorf("missing coverage header: %v", s.Err())
+	}
+	var size int
+	if _, err := fmt.Sscanf(s.Text(), coverageHeader+" %d", &size); err != nil {
+		return nil, fmt.Errorf("bad coverage header %q", s.Text())
+	}
+
+	cov := NewCoverage(size)
+	for line := 2; s.Scan(); line++ {
+		var addr, executed, reads, writes int
+		if _, err := fmt.Sscanf(s.Text(), "%d %d %d %d", &addr, &executed, &reads, &writes); err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		if addr < 0 || addr >= size {
+			return nil, fmt.Errorf("line %d: address %d out of %d cells", line, addr, size)
+		}
+		cov.Executed[addr] += executed
+		cov.Reads[addr] += reads
+		cov.Writes[addr] += writes
+	}
+	return cov, s.Err()
+}
+
+// CoverageStatus classifies the cells of a program after running it.
+type CoverageStatus int
+
+const (
+	// NotCovered cells were neither executed nor used as data.
+	NotCovered CoverageStatus = iota
+	// Covered cells belong to an instruction that was executed.
+	Covered
+	// DataOnly cells were read or written, but never executed.
+	DataOnly
+)
+
+func (s CoverageStatus) String() string {
+	switch s {
+	case Covered:
+		return "executed"
+	case DataOnly:
+		return "data"
+	}
+	return "not executed"
+}
+
+// CoverageLine is a line of the disassembly of a program, annotated with
+// its coverage.
+type CoverageLine struct {
+	Addr        int
+	Size        int
+	Text        string
+	Instruction bool // whether the line could be decoded as an instruction.
+	Status      CoverageStatus
+	Count       int // times executed, or accessed as data for DataOnly lines.
+}
+
+// Listing disassembles the program and annotates each instruction with its
+// coverage. Instructions are decoded from the addresses that were executed,
+// cells only used as data are listed one by one, and the rest is decoded as
+// code where possible. Addresses past the end of the coverage, which may come
+// from a shorter program, are not covered.
+func (cov *Coverage) Listing(program []int) []CoverageLine {
+	var lines []CoverageLine
+	for addr := 0; addr < len(program); {
+		line := CoverageLine{Addr: addr, Size: 1, Text: fmt.Sprintf("DATA %d", program[addr])}
+		executed, accessed := count(cov.Executed, addr), count(cov.Reads, addr)+count(cov.Writes, addr)
+		switch {
+		case executed > 0:
+			line.Status, line.Count = Covered, executed
+			if text, next, err := Disassemble(program, addr); err == nil {
+				line.Text, line.Size, line.Instruction = text, next-addr, true
+			}
+		case accessed > 0:
+			line.Status, line.Count = DataOnly, accessed
+		default:
+			if text, next, err := Disassemble(program, addr); err == nil && cov.unused(addr, next) {
+				line.Text, line.Size, line.Instruction = text, next-addr, true
+			}
+		}
+		lines = append(lines, line)
+		addr += line.Size
+	}
+	return lines
+}
+
+// unused reports whether no cell from addr to end, excluded, was executed or
+// accessed as data.
+func (cov *Coverage) unused(addr, end int) bool {
+	for i := addr; i < end; i++ {
+		if count(cov.Executed, i)+count(cov.Reads, i)+count(cov.Writes, i) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// count returns the count at addr, which is 0 past the end of counts.
+func count(counts []int, addr int) int {
+	if addr < len(counts) {
+		return counts[addr]
+	}
+	return 0
+}
@@ -0,0 +1,98 @@
+package intcode
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Parse parses a program written as comma separated integers.
+func Parse(text string) ([]int, error) {
+	var program []int
+	for _, num := range strings.Split(strings.TrimSpace(text), ",") {
+		v, err := strconv.Atoi(strings.TrimSpace(num))
+		if err != nil {
+			return nil, fmt.Errorf("could not parse number %q: %v", num, err)
+		}
+		program = append(program, v)
+	}
+	return program, nil
+}
+
+// Disassemble decodes the instruction at addr in memory, and returns its
+// textual form and the address of the following instruction.
+func Disassemble(memory []int, addr int) (string, int, error) {
+	return Symbols(nil).Disassemble(memory, addr)
+}
+
+// Symbols names some of the addresses of a program.
+type Symbols map[int]string
+
+// addrs returns the addresses with a name, in order.
+func (s Symbols) addrs() []int {
+	addrs := make([]int, 0, len(s))
+	for addr := range s {
+		addrs = append(addrs, addr)
+	}
+	sort.Ints(addrs)
+	return addrs
+}
+
+// Disassemble is like the Disassemble function, but shows the names of the
+// addresses accessed by the instruction, and of the addresses it jumps to,
+// instead of their values.
+func (s Symbols) Disassemble(memory []int, addr int) (string, int, error) {
+	ins, next, err := decode(memory, addr)
+	if err != nil {
+		return "", next, err
+	}
+	if len(s) > 0 {
+		s.name(ins)
+	}
+	return ins.String(), next, nil
+}
+
+// decode decodes the instruction at addr in memory, and returns it with the
+// address of the following instruction, or the address to resume decoding
+// from if it fails.
+func decode(memory []int, addr int) (instruction, int, error) {
+	if addr < 0 || addr >= len(memory) {
+		return nil, addr, fmt.Errorf("address %d out of memory", addr)
+	}
+	h, err := decodeHeader(memory[addr])
+	if err != nil {
+		return nil, addr + 1, err
+	}
+	if addr+h.size() > len(memory) {
+		return nil, len(memory), fmt.Errorf("truncated instruction at %d", addr)
+	}
+	ins := newInstruction(h.op)
+	ins.parse(parseParameters(memory, addr, h))
+	return ins, addr + h.size(), nil
+}
+
+// name sets the names of the parameters of ins which have one.
+func (s Symbols) name(ins instruction) {
+	set := func(p *parameter) {
+		if p.mode == positionMode {
+			p.name = s[p.value]
+		}
+	}
+	switch ins := ins.(type) {
+	case binaryOp:
+		op := ins.operands()
+		set(&op.src1)
+		set(&op.src2)
+		set(&op.dest)
+	case unaryOp:
+		set(ins.argument())
+	case *condJumpInstruction:
+		set(&ins.cond)
+		set(&ins.target)
+		// An immediate target is the address of an instruction.
+		if ins.target.mode == immediateMode {
+			ins.target.name = s[ins.target.value]
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package intcode
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Snapshot returns a copy of the state of the computer: its memory and
+// registers, and whether it halted. The copy has no channels nor debugging
+// facilities, and can be compared with Diff, dumped, or run on its own.
+func (c *Computer) Snapshot() *Computer {
+	s := NewComputer(c.cells, nil, nil)
+	s.nextInst, s.relBase, s.done = c.nextInst, c.relBase, c.done
+	return s
+}
+
+// CellDiff is a memory cell holding different values in two memories.
+type CellDiff struct {
+	Addr, A, B int
+}
+
+// DiffMemory returns the cells holding different values in a and b, in
+// order. Cells beyond the end of a memory are taken to hold zero.
+func DiffMemory(a, b []int) []CellDiff {
+	var diffs []CellDiff
+	for addr := 0; addr < len(a) || addr < len(b); addr++ {
+		var va, vb int
+		if addr < len(a) {
+			va = a[addr]
+		}
+		if addr < len(b) {
+			vb = b[addr]
+		}
+		if va != vb {
+			diffs = append(diffs, CellDiff{addr, va, vb})
+		}
+	}
+	return diffs
+}
+
+// Diff returns the cells holding different values in the memories of two
+// computers, such as a computer and an earlier snapshot of it.
+func Diff(a, b *Computer) []CellDiff { return DiffMemory(a.cells, b.cells) }
+
+// DumpOptions configures Dump.
+type DumpOptions struct {
+	// Width is the number of cells per row, 10 if zero.
+	Width int
+	// Window is the number of rows shown before and after the row of the
+	// PC. If zero, the whole memory is shown.
+	Window int
+	// Since, if not nil, is an earlier snapshot of the computer, and the
+	// cells changed since then are highlighted.
+	Since *Computer
+	// Symbols are used to name the instruction under the PC and the cells
+	// of every row.
+	Symbols Symbols
+	// Color highlights cells with ANSI escape sequences, rather than by
+	// prefixing them with > for the PC and * for changed cells.
+	Color bool
+}
+
+const (
+	ansiPC      = "\x1b[7m"
+	ansiChanged = "\x1b[1;33m"
+	ansiReset   = "\x1b[0m"
+)
+
+// Dump writes the registers of the computer, the instruction under its PC,
+// and its memory, one row of cells at a time after the address of the first
+// one. With a window, the rows holding cells changed since the snapshot are
+// shown too.
+func (c *Computer) Dump(w io.Writer, opts DumpOptions) error {
+	width := opts.Width
+	if width <= 0 {
+		width = 10
+	}
+	changed := make(map[int]bool)
+	if opts.Since != nil {
+		for _, d := range Diff(opts.Since, c) {
+			changed[d.Addr] = true
+		}
+	}
+
+	bw := bufio.NewWriter(w)
+	c.dumpRegisters(bw, opts.Symbols)
+
+	rows := (len(c.cells) + width - 1) / width
+	shown := func(row int) bool { return true }
+	if opts.Window > 0 {
+		pcRow := c.nextInst / width
+		shown = func(row int) bool {
+			if row >= pcRow-opts.Window && row <= pcRow+opts.Window {
+				return true
+			}
+			for addr := row * width; addr < (row+1)*width; addr++ {
+				if changed[addr] {
+					return true
+				}
+			}
+			return false
+		}
+	}
+	skipped := false
+	for row := 0; row < rows; row++ {
+		if !shown(row) {
+			skipped = true
+			continue
+		}
+		if skipped {
+			fmt.Fprintln(bw, "   ...")
+			skipped = false
+		}
+		c.dumpRow(bw, row*width, (row+1)*width, changed, opts)
+	}
+	if skipped {
+		fmt.Fprintln(bw, "   ...")
+	}
+	return bw.Flush()
+}
+
+func (c *Computer) dumpRow(w io.Writer, from, to int, changed map[int]bool, opts DumpOptions) {
+	if to > len(c.cells) {
+		to = len(c.cells)
+	}
+	fmt.Fprintf(w, "%6d:", from)
+	for addr := from; addr < to; addr++ {
+		mark, color := " ", ""
+		switch {
+		case addr == c.nextInst && !c.done:
+			mark, color = ">", ansiPC
+		case changed[addr]:
+			mark, color = "*", ansiChanged
+		}
+		if !opts.Color || color == "" {
+			fmt.Fprintf(w, " %s%6d", mark, c.cells[addr])
+		} else {
+			fmt.Fprintf(w, "  %s%6d%s", color, c.cells[addr], ansiReset)
+		}
+	}
+	if names := opts.Symbols.between(from, to); len(names) > 0 {
+		fmt.Fprintf(w, "  ; %s", strings.Join(names, " "))
+	}
+	fmt.Fprintln(w)
+}
+
+func (c *Computer) dumpRegisters(w io.Writer, syms Symbols) {
+	if c.done {
+		fmt.Fprintf(w, "halted at %d, rb %d\n", c.nextInst, c.relBase)
+		return
+	}
+	inst, _, err := syms.Disassemble(c.cells, c.nextInst)
+	if err != nil {
+		inst = err.Error()
+	}
+	pc := fmt.Sprint(c.nextInst)
+	if name := syms[c.nextInst]; name != "" {
+		pc += " <" + name + ">"
+	}
+	fmt.Fprintf(w, "pc %s, rb %d: %s\n", pc, c.relBase, inst)
+}
+
+// between returns the symbols of the addresses from from to to, excluded, as
+// name@addr.
+func (s Symbols) between(from, to int) []string {
+	var addrs []int
+	for addr := range s {
+		if addr >= from && addr < to {
+			addrs = append(addrs, addr)
+		}
+	}
+	sort.Ints(addrs)
+	names := make([]string, len(addrs))
+	for i, addr := range addrs {
+		names[i] = fmt.Sprintf("%s@%d", s[addr], addr)
+	}
+	return names
+}
@@ -0,0 +1,144 @@
+package intcode
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"sync"
+)
+
+// A Func runs a program as a function of its inputs, for the programs that
+// read all of their inputs and then produce their outputs. It is prepared
+// once and can be called any number of times, concurrently, each call
+// running on its own copy of the program in a computer reused from earlier
+// calls.
+//
+// If the verifier proves that the code of the program is never rewritten,
+// it is decoded and optimized once, on the first call, and the calls run
+// that compiled code instead of decoding every instruction they run.
+type Func struct {
+	program []int
+	hash    [sha256.Size]byte
+	pool    Pool
+
+	once sync.Once
+	code []compiledOp // nil if the program can't be compiled.
+	// interpreted makes the calls interpret the program, without ever
+	// compiling it.
+	interpreted bool
+
+	// Memory makes calls return the final memory of the program.
+	Memory bool
+	// HaltOnEOF makes calls needing more inputs than given halt, rather
+	// than fail with ErrInputExhausted.
+	HaltOnEOF bool
+	// CheckOverflow makes calls fail when an addition or multiplication
+	// overflows, as it does for a Computer.
+	CheckOverflow bool
+	// Cache, if not nil, memoizes the results of the calls.
+	Cache *Cache
+}
+
+// NewFunc returns a function running a copy of program. Its options must be
+// set before it is first called, or asked whether it is compiled.
+func NewFunc(program []int) *Func {
+	p := make([]int, len(program))
+	copy(p, program)
+	return &Func{program: p, hash: programHash(p)}
+}
+
+// Result is the result of a call to a Func.
+type Result struct {
+	Outputs []int
+	// Memory is the final memory of the program, if the Func returns it.
+	Memory []int
+	// Steps is the number of instructions run, including the final halt.
+	Steps int
+}
+
+// Call runs the program with the given inputs until it halts, and returns its
+// outputs. If the program fails, the result holds what it did until then,
+// and the error wraps the one it failed with along with its PC.
+func (f *Func) Call(inputs ...int) (Result, error) {
+	return f.CallContext(context.Background(), inputs...)
+}
+
+// CallContext is like Call, but gives up when ctx is canceled.
+func (f *Func) CallContext(ctx context.Context, inputs ...int) (Result, error) {
+	if f.Cache != nil {
+		return f.cachedCall(ctx, inputs)
+	}
+	return f.call(ctx, inputs)
+}
+
+// Compiled reports whether the calls run compiled code.
+func (f *Func) Compiled() bool {
+	f.compile()
+	return f.code != nil
+}
+
+func (f *Func) compile() {
+	if f.interpreted {
+		return
+	}
+	f.once.Do(func() { f.code = compile(f.program, f.CheckOverflow) })
+}
+
+func (f *Func) call(ctx context.Context, inputs []int) (Result, error) {
+	f.compile()
+	c := f.pool.Get(f.program, nil, nil)
+	defer f.pool.Put(c)
+
+	var (
+		res Result
+		err error
+	)
+	if f.code != nil {
+		err = f.runCompiled(ctx, c, inputs, &res)
+	} else {
+		c.HaltOnEOF, c.CheckOverflow = f.HaltOnEOF, f.CheckOverflow
+		c.Provide(inputs...)
+		err = f.run(ctx, c, &res)
+	}
+	if f.Memory {
+		res.Memory = append([]int(nil), c.cells...)
+	}
+	return res, err
+}
+
+func (f *Func) run(ctx context.Context, c *Computer, res *Result) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+		ev, err := c.Step()
+		if err != nil {
+			return fmt.Errorf("at %d: %w", ev.PC, err)
+		}
+		switch ev.Kind {
+		case NeedsInput:
+			if err := c.inputExhausted(); err != nil {
+				return fmt.Errorf("at %d: %w", ev.PC, err)
+			}
+			return nil
+		case Output:
+			res.Outputs = append(res.Outputs, ev.Value)
+		}
+		res.Steps++
+		if ev.Kind == Halted {
+			return nil
+		}
+	}
+}
+
+// Eval runs program with the given inputs until it halts, and returns its
+// outputs.
+func Eval(program []int, inputs ...int) ([]int, error) {
+	// The program is never modified and nothing is cached, so it needs
+	// neither a copy nor a hash. It is run once, which never pays back
+	// compiling it, so it is interpreted.
+	res, err := (&Func{program: program, interpreted: true}).Call(inputs...)
+	return res.Outputs, err
+}
@@ -0,0 +1,297 @@
+package intcode
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrHistoryStart is returned when stepping back beyond the oldest step still
+// kept in the history of a computer.
+var ErrHistoryStart = errors.New("reached the start of the recorded history")
+
+// RecordHistory makes the computer record every step it runs from now on, so
+// they can be undone with StepBack, ReverseUntil and Rewind.
+//
+// The history is made of an undo log, with the memory writes, jumps and
+// inputs of each step, and of periodic checkpoints holding a copy of the
+// whole memory. Steps older than the undo log are undone by going back to a
+// checkpoint and running the program again from there. The budget is the
+// number of memory cells the history may use. Once it's full, the oldest
+// steps are discarded.
+//
+// Outputs can't be taken back: running forward again after going back in
+// time sends them again, unless the program had halted and closed stdout, in
+// which case they are not sent at all.
+func (c *Computer) RecordHistory(budget int) {
+	h := &history{budget: budget, interval: budget / 4}
+	if h.interval < 1 {
+		h.interval = 1
+	}
+	c.hist = h
+	h.checkpoint(c)
+}
+
+// Steps returns the number of steps run since the history started being
+// recorded, which is the identifier of the current step for Rewind.
+func (c *Computer) Steps() int {
+	if c.hist == nil {
+		return 0
+	}
+	return c.hist.step
+}
+
+// StepBack undoes the last step, and returns the event it had produced.
+func (c *Computer) StepBack() (Event, error) {
+	h := c.hist
+	if h == nil {
+		return Event{}, errors.New("history is not being recorded")
+	}
+	if h.step == 0 {
+		return Event{}, ErrHistoryStart
+	}
+	if len(h.log) == 0 {
+		// Go back to the previous checkpoint and replay the program up to
+		// this step, which fills the undo log again.
+		if err := c.replay(h.step-1, h.step); err != nil {
+			return Event{}, err
+		}
+	}
+	ev := c.undo()
+	h.trim()
+	return ev, nil
+}
+
+// ReverseUntil steps back until cond returns true for the event of the step
+// undone, and returns that event. The program is left right before that step
+// ran, with its PC at the address of the instruction.
+func (c *Computer) ReverseUntil(cond func(Event) bool) (Event, error) {
+	for {
+		ev, err := c.StepBack()
+		if err != nil || cond(ev) {
+			return ev, err
+		}
+	}
+}
+
+// Rewind goes back to the state of the computer right before the given step.
+func (c *Computer) Rewind(step int) error {
+	h := c.hist
+	if h == nil {
+		return errors.New("history is not being recorded")
+	}
+	if step < 0 || step > h.step {
+		return fmt.Errorf("step %d is not in the history", step)
+	}
+	if step < h.step-len(h.log) {
+		if err := c.replay(step, step); err != nil {
+			return err
+		}
+	}
+	for h.step > step {
+		c.undo()
+	}
+	h.trim()
+	return nil
+}
+
+type cellWrite struct{ addr, old int }
+
+// undoRecord holds what is needed to undo a step.
+type undoRecord struct {
+	ev      Event
+	relBase int
+	size    int // of memory, before the step grew it.
+	writes  []cellWrite
+}
+
+type checkpoint struct {
+	step    int
+	pc      int
+	relBase int
+	cells   []int
+}
+
+type loggedInput struct{ step, value int }
+
+type history struct {
+	budget   int
+	interval int
+
+	step        int // number of steps run.
+	current     *undoRecord
+	log         []undoRecord // records for the steps from step-len(log).
+	logCost     int
+	checkpoints []checkpoint
+	inputs      []loggedInput // since the oldest checkpoint.
+	replaying   bool
+}
+
+// isReplaying reports whether the steps being run are replayed, which is
+// never the case without a history.
+func (h *history) isReplaying() bool { return h != nil && h.replaying }
+
+func (h *history) begin(c *Computer) {
+	h.current = &undoRecord{relBase: c.relBase, size: len(c.cells)}
+}
+
+func (h *history) wrote(addr, old int) {
+	if h.current != nil {
+		h.current.writes = append(h.current.writes, cellWrite{addr, old})
+	}
+}
+
+func (h *history) end(c *Computer, ev Event, err error) {
+	rec := h.current
+	h.current = nil
+	if err != nil || ev.Kind == NeedsInput {
+		return
+	}
+
+	rec.ev = ev
+	h.log = append(h.log, *rec)
+	h.logCost += 1 + len(rec.writes)
+	if ev.Kind == Input {
+		h.inputs = append(h.inputs, loggedInput{h.step, ev.Value})
+	}
+	h.step++
+	if h.step%h.interval == 0 {
+		h.checkpoint(c)
+	}
+	if !h.replaying {
+		h.trim()
+	}
+}
+
+func (h *history) checkpoint(c *Computer) {
+	h.checkpoints = append(h.checkpoints, checkpoint{
+		step:    h.step,
+		pc:      c.nextInst,
+		relBase: c.relBase,
+		cells:   append([]int(nil), c.cells...),
+	})
+}
+
+func (h *history) cost() int {
+	cost := h.logCost + len(h.inputs)
+	for _, cp := range h.checkpoints {
+		cost += len(cp.cells)
+	}
+	return cost
+}
+
+// trim discards the oldest history until it fits in the budget. Undo records
+// that can be rebuilt from a later checkpoint go first, then the oldest
+// checkpoints, and finally the undo records that are left.
+func (h *history) trim() {
+	for h.cost() > h.budget {
+		first := h.step - len(h.log)
+		switch {
+		case len(h.log) > 0 && first < h.checkpoints[len(h.checkpoints)-1].step:
+			h.dropRecord()
+		case len(h.checkpoints) > 1:
+			h.checkpoints = h.checkpoints[1:]
+			h.dropInputs()
+		case len(h.log) > 0:
+			h.dropRecord()
+		default:
+			return
+		}
+	}
+}
+
+func (h *history) dropRecord() {
+	h.logCost -= 1 + len(h.log[0].writes)
+	h.log = h.log[1:]
+	h.dropInputs()
+}
+
+// dropInputs discards the inputs that are no longer needed to replay from a
+// checkpoint or to undo a step.
+func (h *history) dropInputs() {
+	oldest := h.step - len(h.log)
+	if len(h.checkpoints) > 0 && h.checkpoints[0].step < oldest {
+		oldest = h.checkpoints[0].step
+	}
+	for len(h.inputs) > 0 && h.inputs[0].step < oldest {
+		h.inputs = h.inputs[1:]
+	}
+}
+
+// undo reverts the last step in the undo log and returns its event.
+func (c *Computer) undo() Event {
+	h := c.hist
+	rec := h.log[len(h.log)-1]
+	h.log = h.log[:len(h.log)-1]
+	h.logCost -= 1 + len(rec.writes)
+	h.step--
+
+	rec.ev.Watch = nil
+	for i := len(rec.writes) - 1; i >= 0; i-- {
+		w := rec.writes[i]
+		for _, s := range c.stops {
+			if s.access&WriteAccess != 0 && s.from <= w.addr && w.addr <= s.to {
+				rec.ev.Watch = append(rec.ev.Watch, WatchHit{s.id, w.addr, WriteAccess, c.cells[w.addr]})
+			}
+		}
+		c.cells[w.addr] = w.old
+	}
+	c.cells = c.cells[:rec.size]
+	c.nextInst = rec.ev.PC
+	c.relBase = rec.relBase
+	c.done = false
+
+	if rec.ev.Kind == Input {
+		h.inputs = h.inputs[:len(h.inputs)-1]
+		c.pending = append([]int{rec.ev.Value}, c.pending...)
+	}
+	for len(h.checkpoints) > 0 && h.checkpoints[len(h.checkpoints)-1].step > h.step {
+		h.checkpoints = h.checkpoints[:len(h.checkpoints)-1]
+	}
+	return rec.ev
+}
+
+// replay restores the latest checkpoint taken no later than the step from,
+// and runs the program again until it reaches the given step, with the same
+// inputs and without sending any output.
+func (c *Computer) replay(from, step int) error {
+	h := c.hist
+	i := len(h.checkpoints) - 1
+	for i >= 0 && h.checkpoints[i].step > from {
+		i--
+	}
+	if i < 0 {
+		return ErrHistoryStart
+	}
+	cp := h.checkpoints[i]
+	h.checkpoints = h.checkpoints[:i+1]
+
+	var inputs []int
+	for len(h.inputs) > 0 && h.inputs[len(h.inputs)-1].step >= cp.step {
+		inputs = append([]int{h.inputs[len(h.inputs)-1].value}, inputs...)
+		h.inputs = h.inputs[:len(h.inputs)-1]
+	}
+	c.pending = append(inputs, c.pending...)
+
+	// Memory may have grown since the checkpoint.
+	c.cells = append(c.cells[:0], cp.cells...)
+	c.nextInst = cp.pc
+	c.relBase = cp.relBase
+	c.done = false
+	h.step = cp.step
+	h.log = nil
+	h.logCost = 0
+
+	stdout := c.stdout
+	c.stdout = nil
+	h.replaying = true
+	defer func() {
+		c.stdout = stdout
+		h.replaying = false
+	}()
+	for h.step < step {
+		if _, err := c.Step(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
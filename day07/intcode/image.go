@@ -0,0 +1,442 @@
+package intcode
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// An Image is a program along with optional metadata: names for some of its
+// addresses, the source lines its instructions were written on, and named
+// sets of inputs to start it with, such as the ones for each part of a
+// puzzle.
+//
+// Images are stored either as text or in a compact binary format, and can be
+// converted between them without losing anything. The text format is the
+// usual comma separated program, followed by a line for each piece of
+// metadata:
+//
+//	#symbol 100 counter
+//	#source 4 12 countdown.ic
+//	#input part1 1,2,3
+type Image struct {
+	Program []int
+	Symbols Symbols
+	Source  []SourceLine // sorted by address.
+	Inputs  []NamedInput
+}
+
+// SourceLine locates the source of the instruction at Addr.
+type SourceLine struct {
+	Addr int
+	File string
+	Line int
+}
+
+func (s SourceLine) String() string { return fmt.Sprintf("%s:%d", s.File, s.Line) }
+
+// NamedInput is a named set of inputs to start a program with.
+type NamedInput struct {
+	Name   string
+	Values []int
+}
+
+// SourceOf returns the source line of the instruction at addr, if known.
+func (im *Image) SourceOf(addr int) (SourceLine, bool) {
+	i := sort.Search(len(im.Source), func(i int) bool { return im.Source[i].Addr >= addr })
+	if i < len(im.Source) && im.Source[i].Addr == addr {
+		return im.Source[i], true
+	}
+	return SourceLine{}, false
+}
+
+// Input returns the inputs with the given name.
+func (im *Image) Input(name string) ([]int, bool) {
+	for _, in := range im.Inputs {
+		if in.Name == name {
+			return in.Values, true
+		}
+	}
+	return nil, false
+}
+
+// check verifies that the metadata can be written in both formats.
+func (im *Image) check() error {
+	for addr, name := range im.Symbols {
+		if !validName(name) {
+			return fmt.Errorf("invalid name %q for symbol at %d", name, addr)
+		}
+	}
+	for _, s := range im.Source {
+		if s.File == "" || strings.TrimSpace(s.File) != s.File || strings.ContainsAny(s.File, "\r\n") {
+			return fmt.Errorf("invalid file name %q in source map", s.File)
+		}
+	}
+	if !sort.SliceIsSorted(im.Source, func(i, j int) bool { return im.Source[i].Addr < im.Source[j].Addr }) {
+		return errors.New("source map is not sorted by address")
+	}
+	for _, in := range im.Inputs {
+		if !validName(in.Name) {
+			return fmt.Errorf("invalid name %q for inputs", in.Name)
+		}
+	}
+	return nil
+}
+
+// validName reports whether name is a non empty identifier made of letters,
+// digits and underscores.
+func validName(name string) bool {
+	for _, r := range name {
+		if r != '_' && !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			return false
+		}
+	}
+	return name != ""
+}
+
+// Load reads an image in either format, detecting which one is used.
+func Load(data []byte) (*Image, error) {
+	if bytes.HasPrefix(data, []byte(imageMagic)) {
+		return DecodeImage(bytes.NewReader(data))
+	}
+	return ParseImage(string(data))
+}
+
+// ParseImage parses an image in the text format. A plain program, without
+// any metadata, is a valid image.
+func ParseImage(text string) (*Image, error) {
+	lines := strings.Split(strings.TrimSpace(text), "\n")
+	var code []string
+	for len(lines) > 0 && !strings.HasPrefix(strings.TrimSpace(lines[0]), "#") {
+		code, lines = append(code, lines[0]), lines[1:]
+	}
+	program, err := Parse(strings.Join(code, "\n"))
+	if err != nil {
+		return nil, err
+	}
+
+	im := &Image{Program: program}
+	for i, line := range lines {
+		if err := im.parseMetadata(strings.TrimSpace(line)); err != nil {
+			return nil, fmt.Errorf("line %d: %v", len(code)+i+1, err)
+		}
+	}
+	if err := im.check(); err != nil {
+		return nil, err
+	}
+	return im, nil
+}
+
+func (im *Image) parseMetadata(line string) error {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return nil
+	}
+	switch fields[0] {
+	case "#symbol":
+		if len(fields) != 3 {
+			return errors.New("expected #symbol addr name")
+		}
+		addr, err := strconv.Atoi(fields[1])
+		if err != nil {
+			return err
+		}
+		if im.Symbols == nil {
+			im.Symbols = make(Symbols)
+		}
+		im.Symbols[addr] = fields[2]
+	case "#source":
+		// The file name comes last, since it may contain spaces.
+		parts := strings.SplitN(line, " ", 4)
+		if len(parts) != 4 {
+			return errors.New("expected #source addr line file")
+		}
+		s := SourceLine{File: parts[3]}
+		var err error
+		if s.Addr, err = strconv.Atoi(parts[1]); err != nil {
+			return err
+		}
+		if s.Line, err = strconv.Atoi(parts[2]); err != nil {
+			return err
+		}
+		im.Source = append(im.Source, s)
+	case "#input":
+		if len(fields) != 2 && len(fields) != 3 {
+			return errors.New("expected #input name values")
+		}
+		in := NamedInput{Name: fields[1]}
+		if len(fields) == 3 {
+			var err error
+			if in.Values, err = Parse(fields[2]); err != nil {
+				return err
+			}
+		}
+		im.Inputs = append(im.Inputs, in)
+	default:
+		return fmt.Errorf("unknown metadata %q", fields[0])
+	}
+	return nil
+}
+
+// WriteText writes the image in the text format.
+func (im *Image) WriteText(w io.Writer) error {
+	if err := im.check(); err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(w)
+	for i, v := range im.Program {
+		if i > 0 {
+			bw.WriteByte(',')
+		}
+		bw.WriteString(strconv.Itoa(v))
+	}
+	bw.WriteByte('\n')
+	for _, addr := range im.Symbols.addrs() {
+		fmt.Fprintf(bw, "#symbol %d %s\n", addr, im.Symbols[addr])
+	}
+	for _, s := range im.Source {
+		fmt.Fprintf(bw, "#source %d %d %s\n", s.Addr, s.Line, s.File)
+	}
+	for _, in := range im.Inputs {
+		values := make([]string, len(in.Values))
+		for i, v := range in.Values {
+			values[i] = strconv.Itoa(v)
+		}
+		fmt.Fprintln(bw, strings.TrimSpace("#input "+in.Name+" "+strings.Join(values, ",")))
+	}
+	return bw.Flush()
+}
+
+// The binary format starts with imageMagic and a version, followed by
+// sections made of a tag, the length of their payload and the payload. All
+// numbers are varints, signed ones being zig-zag encoded, and strings are
+// prefixed with their length. Readers skip the sections they don't know,
+// but reject versions newer than their own.
+const (
+	imageMagic   = "\x00ICB"
+	imageVersion = 1
+
+	sectionCells   = 1
+	sectionSymbols = 2
+	sectionSource  = 3
+	sectionInputs  = 4
+)
+
+// EncodeImage writes the image in the binary format.
+func EncodeImage(w io.Writer, im *Image) error {
+	if err := im.check(); err != nil {
+		return err
+	}
+	var out encoder
+	out.buf = append(out.buf, imageMagic...)
+	out.uvarint(imageVersion)
+
+	var sec encoder
+	sec.uvarint(uint64(len(im.Program)))
+	for _, v := range im.Program {
+		sec.varint(v)
+	}
+	out.section(sectionCells, &sec)
+
+	if len(im.Symbols) > 0 {
+		sec.uvarint(uint64(len(im.Symbols)))
+		for _, addr := range im.Symbols.addrs() {
+			sec.varint(addr)
+			sec.string(im.Symbols[addr])
+		}
+		out.section(sectionSymbols, &sec)
+	}
+	if len(im.Source) > 0 {
+		sec.uvarint(uint64(len(im.Source)))
+		for _, s := range im.Source {
+			sec.varint(s.Addr)
+			sec.string(s.File)
+			sec.varint(s.Line)
+		}
+		out.section(sectionSource, &sec)
+	}
+	if len(im.Inputs) > 0 {
+		sec.uvarint(uint64(len(im.Inputs)))
+		for _, in := range im.Inputs {
+			sec.string(in.Name)
+			sec.uvarint(uint64(len(in.Values)))
+			for _, v := range in.Values {
+				sec.varint(v)
+			}
+		}
+		out.section(sectionInputs, &sec)
+	}
+
+	_, err := w.Write(out.buf)
+	return err
+}
+
+// DecodeImage reads an image in the binary format.
+func DecodeImage(r io.Reader) (*Image, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.HasPrefix(data, []byte(imageMagic)) {
+		return nil, errors.New("not a binary Intcode image")
+	}
+	in := decoder{buf: data[len(imageMagic):]}
+	if v := in.uvarint(); in.err == nil && v != imageVersion {
+		return nil, fmt.Errorf("unsupported image version %d", v)
+	}
+
+	im := new(Image)
+	cells := false
+	for in.err == nil && len(in.buf) > 0 {
+		tag := in.uvarint()
+		sec := decoder{buf: in.bytes(in.uvarint())}
+		if in.err != nil {
+			break
+		}
+		switch tag {
+		case sectionCells:
+			cells = true
+			im.Program = make([]int, sec.count(1))
+			for i := range im.Program {
+				im.Program[i] = sec.varint()
+			}
+		case sectionSymbols:
+			im.Symbols = make(Symbols)
+			for n := sec.count(2); n > 0; n-- {
+				addr := sec.varint()
+				im.Symbols[addr] = sec.string()
+			}
+		case sectionSource:
+			im.Source = make([]SourceLine, sec.count(3))
+			for i := range im.Source {
+				im.Source[i] = SourceLine{Addr: sec.varint(), File: sec.string(), Line: sec.varint()}
+			}
+		case sectionInputs:
+			im.Inputs = make([]NamedInput, sec.count(2))
+			for i := range im.Inputs {
+				im.Inputs[i].Name = sec.string()
+				im.Inputs[i].Values = make([]int, sec.count(1))
+				for j := range im.Inputs[i].Values {
+					im.Inputs[i].Values[j] = sec.varint()
+				}
+			}
+		}
+		if sec.err == nil && len(sec.buf) > 0 {
+			sec.err = errors.New("unexpected data at the end of the section")
+		}
+		if sec.err != nil {
+			return nil, fmt.Errorf("section %d: %v", tag, sec.err)
+		}
+	}
+	if in.err != nil {
+		return nil, in.err
+	}
+	if !cells {
+		return nil, errors.New("image has no cells")
+	}
+	if err := im.check(); err != nil {
+		return nil, err
+	}
+	return im, nil
+}
+
+type encoder struct{ buf []byte }
+
+func (e *encoder) uvarint(v uint64) {
+	var b [binary.MaxVarintLen64]byte
+	e.buf = append(e.buf, b[:binary.PutUvarint(b[:], v)]...)
+}
+
+func (e *encoder) varint(v int) {
+	var b [binary.MaxVarintLen64]byte
+	e.buf = append(e.buf, b[:binary.PutVarint(b[:], int64(v))]...)
+}
+
+func (e *encoder) string(s string) {
+	e.uvarint(uint64(len(s)))
+	e.buf = append(e.buf, s...)
+}
+
+// section appends the content of sec as a section with the given tag, and
+// empties sec so it can be reused.
+func (e *encoder) section(tag uint64, sec *encoder) {
+	e.uvarint(tag)
+	e.uvarint(uint64(len(sec.buf)))
+	e.buf = append(e.buf, sec.buf...)
+	sec.buf = sec.buf[:0]
+}
+
+// A decoder reads values from buf until the first error, after which it
+// only returns zero values.
+type decoder struct {
+	buf []byte
+	err error
+}
+
+var errTruncated = errors.New("truncated image")
+
+func (d *decoder) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(d.buf)
+	if n <= 0 {
+		d.err = errTruncated
+		return 0
+	}
+	d.buf = d.buf[n:]
+	return v
+}
+
+func (d *decoder) varint() int {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(d.buf)
+	if n <= 0 {
+		d.err = errTruncated
+		return 0
+	}
+	d.buf = d.buf[n:]
+	if int64(int(v)) != v {
+		d.err = fmt.Errorf("value %d out of range", v)
+	}
+	return int(v)
+}
+
+func (d *decoder) bytes(n uint64) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if n > uint64(len(d.buf)) {
+		d.err = errTruncated
+		return nil
+	}
+	b := d.buf[:n]
+	d.buf = d.buf[n:]
+	return b
+}
+
+func (d *decoder) string() string { return string(d.bytes(d.uvarint())) }
+
+// count reads the number of elements of a list, where each element takes at
+// least size bytes, so that a corrupted count can't make the reader allocate
+// more than the size of the image.
+func (d *decoder) count(size int) int {
+	n := d.uvarint()
+	if n > uint64(len(d.buf)/size) {
+		if d.err == nil {
+			d.err = errTruncated
+		}
+		return 0
+	}
+	return int(n)
+}
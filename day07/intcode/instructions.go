@@ -1,8 +1,8 @@
 package intcode
 
 import (
+	"errors"
 	"fmt"
-	"log"
 )
 
 type opCode int
@@ -16,42 +16,93 @@ const (
 	opJumpIfFalse opCode = 6
 	opLessThan    opCode = 7
 	opEquals      opCode = 8
+	opRelBase     opCode = 9
 	opHalt        opCode = 99
 )
 
+// maxInstructionSize is the number of cells used by the longest instructions.
+const maxInstructionSize = 4
+
+// An opHeader is the decoded first cell of an instruction: its op code and
+// the modes of its parameters. Decoding it is shared by Computer and
+// BigComputer.
+type opHeader struct {
+	op     opCode
+	params int // number of parameters.
+	modes  [maxInstructionSize - 1]paramMode
+}
+
+func decodeHeader(code int) (opHeader, error) {
+	h := opHeader{op: opCode(code % 100)}
+	switch h.op {
+	case opAdd, opMult, opLessThan, opEquals:
+		h.params = 3
+	case opJumpIfTrue, opJumpIfFalse:
+		h.params = 2
+	case opInput, opOutput, opRelBase:
+		h.params = 1
+	case opHalt:
+	default:
+		return h, fmt.Errorf("unknown op code %d", h.op)
+	}
+	modes := code / 100
+	for i := 0; i < h.params; i++ {
+		h.modes[i] = paramMode(modes % 10)
+		if h.modes[i] > relativeMode {
+			return h, fmt.Errorf("unknown mode %d for parameter %d", h.modes[i], i+1)
+		}
+		modes /= 10
+	}
+	return h, nil
+}
+
+// size returns the number of cells used by the instruction.
+func (h opHeader) size() int { return 1 + h.params }
+
+// errImmediateWrite is returned by instructions writing through an
+// immediate mode parameter.
+var errImmediateWrite = errors.New("wrote into an immediate parameter")
+
 type instruction interface {
-	parse(c *Computer)
-	run(c *Computer)
+	parse(params []parameter)
+	run(c *Computer) error
 	String() string
 }
 
-func newInstruction(val int) (instruction, error) {
-	op := opCode(val % 100)
+func newInstruction(op opCode) instruction {
 	switch op {
 	case opAdd:
-		return new(addInstruction), nil
+		return new(addInstruction)
 	case opMult:
-		return new(multInstruction), nil
+		return new(multInstruction)
 	case opInput:
-		return new(inputInstruction), nil
+		return new(inputInstruction)
 	case opOutput:
-		return new(outputInstruction), nil
+		return new(outputInstruction)
 	case opJumpIfTrue, opJumpIfFalse:
-		return &condJumpInstruction{jumpOn: op == opJumpIfTrue}, nil
+		return &condJumpInstruction{jumpOn: op == opJumpIfTrue}
 	case opLessThan:
-		return new(lessThanInstruction), nil
+		return new(lessThanInstruction)
 	case opEquals:
-		return new(equalsInstruction), nil
-	case opHalt:
-		return new(haltInstruction), nil
-	default:
-		return nil, fmt.Errorf("unknown op code %d", op)
+		return new(equalsInstruction)
+	case opRelBase:
+		return new(relBaseInstruction)
 	}
+	return new(haltInstruction)
 }
 
 type addInstruction struct{ binaryOpInstruction }
 
-func (i *addInstruction) run(c *Computer) { i.dest.write(c, i.src1.read(c)+i.src2.read(c)) }
+func (i *addInstruction) run(c *Computer) error {
+	a, b, err := i.values(c)
+	if err != nil {
+		return err
+	}
+	if c.CheckOverflow && addOverflows(a, b) {
+		return &OverflowError{Op: "+", A: a, B: b}
+	}
+	return i.dest.write(c, a+b)
+}
 
 func (i *addInstruction) String() string {
 	return fmt.Sprintf("ADD %v = %v + %v", i.dest, i.src1, i.src2)
@@ -59,25 +110,51 @@ func (i *addInstruction) String() string {
 
 type multInstruction struct{ binaryOpInstruction }
 
-func (i *multInstruction) run(c *Computer) { i.dest.write(c, i.src1.read(c)*i.src2.read(c)) }
+func (i *multInstruction) run(c *Computer) error {
+	a, b, err := i.values(c)
+	if err != nil {
+		return err
+	}
+	if c.CheckOverflow && mulOverflows(a, b) {
+		return &OverflowError{Op: "*", A: a, B: b}
+	}
+	return i.dest.write(c, a*b)
+}
 
 func (i *multInstruction) String() string {
 	return fmt.Sprintf("MUL %v = %v * %v", i.dest, i.src1, i.src2)
 }
 
-type outputInstruction struct{ unaryOpInstruction }
+type outputInstruction struct {
+	unaryOpInstruction
+	value int
+}
 
 func (i *outputInstruction) String() string { return fmt.Sprintf("OUTPUT %v", i.arg) }
 
-func (i *outputInstruction) run(c *Computer) { c.stdout <- i.arg.read(c) }
+func (i *outputInstruction) run(c *Computer) error {
+	val, err := i.arg.read(c)
+	if err != nil {
+		return err
+	}
+	i.value = val
+	return c.send(i.value)
+}
 
-type inputInstruction struct{ unaryOpInstruction }
+type inputInstruction struct {
+	unaryOpInstruction
+	value int
+}
 
 func (i *inputInstruction) String() string { return fmt.Sprintf("INPUT %v", i.arg) }
 
-func (i *inputInstruction) run(c *Computer) {
-	val := <-c.stdin
-	i.arg.write(c, val)
+func (i *inputInstruction) run(c *Computer) error {
+	val, err := c.receive()
+	if err != nil {
+		return err
+	}
+	i.value = val
+	return i.arg.write(c, val)
 }
 
 type condJumpInstruction struct {
@@ -86,8 +163,7 @@ type condJumpInstruction struct {
 	target parameter
 }
 
-func (i *condJumpInstruction) parse(c *Computer) {
-	params := parseParameters(c, 2)
+func (i *condJumpInstruction) parse(params []parameter) {
 	i.cond = params[0]
 	i.target = params[1]
 }
@@ -96,11 +172,17 @@ func (i *condJumpInstruction) String() string {
 	return fmt.Sprintf("JumpIf(%v) %v %v", i.jumpOn, i.cond, i.target)
 }
 
-func (i *condJumpInstruction) run(c *Computer) {
-	if (i.cond.read(c) == 0) == i.jumpOn {
-		return
+func (i *condJumpInstruction) run(c *Computer) error {
+	cond, err := i.cond.read(c)
+	if err != nil || (cond == 0) == i.jumpOn {
+		return err
 	}
-	c.nextInst = i.target.read(c)
+	target, err := i.target.read(c)
+	if err != nil {
+		return err
+	}
+	c.nextInst = target
+	return nil
 }
 
 type lessThanInstruction struct{ binaryOpInstruction }
@@ -109,12 +191,15 @@ func (i *lessThanInstruction) String() string {
 	return fmt.Sprintf("LessThan: %v = %v < %v", i.dest, i.src1, i.src2)
 }
 
-func (i *lessThanInstruction) run(c *Computer) {
-	if i.src1.read(c) < i.src2.read(c) {
-		i.dest.write(c, 1)
-	} else {
-		i.dest.write(c, 0)
+func (i *lessThanInstruction) run(c *Computer) error {
+	a, b, err := i.values(c)
+	if err != nil {
+		return err
+	}
+	if a < b {
+		return i.dest.write(c, 1)
 	}
+	return i.dest.write(c, 0)
 }
 
 type equalsInstruction struct{ binaryOpInstruction }
@@ -123,79 +208,145 @@ func (i *equalsInstruction) String() string {
 	return fmt.Sprintf("Equals: %v = %v == %v", i.dest, i.src1, i.src2)
 }
 
-func (i *equalsInstruction) run(c *Computer) {
-	if i.src1.read(c) == i.src2.read(c) {
-		i.dest.write(c, 1)
-	} else {
-		i.dest.write(c, 0)
+func (i *equalsInstruction) run(c *Computer) error {
+	a, b, err := i.values(c)
+	if err != nil {
+		return err
+	}
+	if a == b {
+		return i.dest.write(c, 1)
 	}
+	return i.dest.write(c, 0)
+}
+
+type relBaseInstruction struct{ unaryOpInstruction }
+
+func (i *relBaseInstruction) String() string { return fmt.Sprintf("RelBase += %v", i.arg) }
+
+func (i *relBaseInstruction) run(c *Computer) error {
+	offset, err := i.arg.read(c)
+	if err != nil {
+		return err
+	}
+	c.relBase += offset
+	return nil
 }
 
 type haltInstruction struct{}
 
-func (i *haltInstruction) parse(c *Computer) {}
+func (i *haltInstruction) parse([]parameter) {}
 func (i *haltInstruction) String() string    { return "HALT" }
-func (i *haltInstruction) run(c *Computer)   { c.done = true }
+func (i *haltInstruction) run(c *Computer) error {
+	c.done = true
+	return nil
+}
 
 // utility instructions
 
+type paramMode int
+
+const (
+	positionMode  paramMode = 0
+	immediateMode paramMode = 1
+	relativeMode  paramMode = 2
+)
+
 type parameter struct {
-	value     int
-	immediate bool
+	value int
+	mode  paramMode
+
+	// name is the symbol shown instead of the value, if any.
+	name string
 }
 
 func (p parameter) String() string {
-	if p.immediate {
+	switch {
+	case p.name != "" && p.mode == immediateMode:
+		return p.name
+	case p.name != "":
+		return "@" + p.name
+	}
+	switch p.mode {
+	case immediateMode:
 		return fmt.Sprint(p.value)
+	case relativeMode:
+		return fmt.Sprintf("@rb%+d", p.value)
 	}
 	return fmt.Sprintf("@%d", p.value)
 }
 
-func (p parameter) read(c *Computer) int {
-	if p.immediate {
-		return p.value
+// addr returns the address a non immediate parameter refers to, given the
+// relative base.
+func (p parameter) addr(relBase int) int {
+	if p.mode == relativeMode {
+		return relBase + p.value
+	}
+	return p.value
+}
+
+func (p parameter) read(c *Computer) (int, error) {
+	if p.mode == immediateMode {
+		return p.value, nil
 	}
-	return c.read(p.value)
+	return c.read(p.addr(c.relBase))
 }
 
-func (p parameter) write(c *Computer, val int) {
-	if p.immediate {
-		log.Fatal("wrote into an immediate parameter")
+func (p parameter) write(c *Computer, val int) error {
+	if p.mode == immediateMode {
+		return errImmediateWrite
 	}
-	c.write(p.value, val)
+	return c.write(p.addr(c.relBase), val)
 }
 
 type unaryOpInstruction struct{ arg parameter }
 
-func (i *unaryOpInstruction) parse(c *Computer) {
-	i.arg = parseParameters(c, 1)[0]
+// unaryOp is implemented by the instructions with a single parameter.
+type unaryOp interface {
+	argument() *parameter
+}
+
+func (i *unaryOpInstruction) argument() *parameter { return &i.arg }
+
+func (i *unaryOpInstruction) parse(params []parameter) {
+	i.arg = params[0]
 }
 
 type binaryOpInstruction struct {
 	src1, src2, dest parameter
 }
 
-func (i *binaryOpInstruction) parse(c *Computer) {
-	params := parseParameters(c, 3)
+// binaryOp is implemented by the instructions with two operands and a
+// result.
+type binaryOp interface {
+	operands() *binaryOpInstruction
+}
+
+func (i *binaryOpInstruction) operands() *binaryOpInstruction { return i }
+
+// values reads the two operands.
+func (i *binaryOpInstruction) values(c *Computer) (a, b int, err error) {
+	if a, err = i.src1.read(c); err != nil {
+		return
+	}
+	b, err = i.src2.read(c)
+	return
+}
+
+func (i *binaryOpInstruction) parse(params []parameter) {
 	i.src1 = params[0]
 	i.src2 = params[1]
 	i.dest = params[2]
 }
 
-func parseParameters(c *Computer, n int) []parameter {
-	idx := c.nextInst
-	c.nextInst += n + 1
-
-	modes := c.cells[idx] / 100
-
-	var params []parameter
-	for i := 1; i <= n; i++ {
-		params = append(params, parameter{
-			c.cells[idx+i],
-			modes%10 == 1,
-		})
-		modes = modes / 10
+// parseParameters returns the parameters of the instruction at addr in
+// memory, reading 0 past its end.
+func parseParameters(memory []int, addr int, h opHeader) []parameter {
+	params := make([]parameter, h.params)
+	for i := range params {
+		params[i].mode = h.modes[i]
+		if a := addr + 1 + i; a < len(memory) {
+			params[i].value = memory[a]
+		}
 	}
-
 	return params
 }
@@ -1,69 +1,370 @@
+// Package intcode implements the Intcode computer used by many of the Advent
+// of Code 2019 puzzles.
+//
+// A Computer receives its inputs from its stdin channel and sends its outputs
+// to its stdout channel. The channels follow these rules:
+//
+//   - stdin belongs to whoever sends the inputs, and is never closed by the
+//     computer reading from it.
+//   - stdout belongs to the computer, which closes it once it stops running,
+//     both when the program halts and when it fails. A goroutine ranging over
+//     the outputs of a computer always terminates, and nothing else must send
+//     to or close that channel.
+//   - Closing stdin signals the end of the input. A computer needing more
+//     input than was sent fails with ErrInputExhausted, or halts if its
+//     HaltOnEOF field is set. A nil stdin provides no input at all.
+//   - A computer stops waiting on its channels as soon as its context is
+//     canceled, and its run returns the context's error.
 package intcode
 
 import (
-	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"strings"
 )
 
 type Computer struct {
 	cells    []int
 	nextInst int
+	relBase  int
 	done     bool
 	stdin    chan int
 	stdout   chan int
+	closed   bool
+	ctx      context.Context
+
+	// pending holds the inputs given through Provide, which are consumed
+	// before reading from stdin.
+	pending []int
+
+	// hist records how to undo each step, if enabled with RecordHistory.
+	hist *history
+
+	// stops holds the breakpoints and watchpoints, and hits the accesses
+	// to watched memory during the current step.
+	stops  []stop
+	lastID int
+	hits   []WatchHit
+
+	// cov records coverage, if enabled with Cover.
+	cov *Coverage
+
+	// rec records the inputs and outputs, if enabled with Record.
+	rec *Recording
+
+	// tr writes a trace of every step, if enabled with Trace.
+	tr *tracer
+
+	// taint tracks the inputs memory depends on, if enabled with TrackTaint.
+	taint *taintTracker
+
+	// HaltOnEOF makes the computer halt, instead of failing with
+	// ErrInputExhausted, when it needs an input and stdin is closed.
+	HaltOnEOF bool
+
+	// CheckOverflow makes additions and multiplications whose result
+	// doesn't fit in an int fail with an *OverflowError, rather than wrap
+	// around.
+	CheckOverflow bool
+
+	// MaxMemory, if positive, is the number of cells memory may grow to,
+	// and DefaultMaxMemory otherwise. Memory grows when a program writes
+	// past its end, and reading past its end gives 0.
+	MaxMemory int
+
+	// nonBlocking makes input and output instructions fail with
+	// errWouldBlock rather than wait on their channels.
+	nonBlocking bool
 }
 
 func NewComputer(program []int, stdin, stdout chan int) *Computer {
 	cells := make([]int, len(program))
 	copy(cells, program)
-	return &Computer{cells: cells, stdin: stdin, stdout: stdout}
+	return &Computer{cells: cells, stdin: stdin, stdout: stdout, ctx: context.Background()}
 }
 
+// Reset makes the computer run a copy of program from the start, as if it had
+// just been created by NewComputer with no channels, reusing its memory when
+// large enough. Everything else about the computer is forgotten: its options,
+// breakpoints, and the history, coverage, recording, trace and taint it was
+// tracking.
+func (c *Computer) Reset(program []int) {
+	cells := c.cells[:0]
+	if cap(cells) < len(program) {
+		cells = make([]int, 0, len(program))
+	}
+	cells = cells[:len(program)]
+	copy(cells, program)
+	*c = Computer{cells: cells, pending: c.pending[:0], hits: c.hits[:0], ctx: context.Background()}
+}
+
+// String dumps the registers and the whole memory of the computer, as Dump
+// does with the default options.
 func (c *Computer) String() string {
-	w := new(bytes.Buffer)
+	var b strings.Builder
+	c.Dump(&b, DumpOptions{})
+	return b.String()
+}
+
+// Run runs the program until it halts or fails, and then closes stdout.
+func (c *Computer) Run() error { return c.RunContext(context.Background()) }
+
+// RunContext is like Run, but gives up when ctx is canceled.
+func (c *Computer) RunContext(ctx context.Context) error {
+	c.ctx = ctx
+	defer c.closeStdout()
 
-	fmt.Fprintf(w, "insptr:\t%d\n", c.nextInst)
-	for i := 0; i < c.nextInst; i++ {
-		fmt.Fprintf(w, "%5d ", c.cells[i])
-		if i%10 == 9 {
-			fmt.Fprintln(w)
+	for !c.done {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+		ev, err := c.Step()
+		if err != nil {
+			return err
 		}
+		if ev.Kind == NeedsInput {
+			return c.inputExhausted()
+		}
+	}
+	return nil
+}
+
+// inputExhausted is called when the program needs an input that will never
+// come, and halts the computer if HaltOnEOF is set.
+func (c *Computer) inputExhausted() error {
+	if !c.HaltOnEOF {
+		return ErrInputExhausted
+	}
+	c.done = true
+	c.closeStdout()
+	return nil
+}
+
+func (c *Computer) closeStdout() {
+	if c.stdout != nil && !c.closed {
+		close(c.stdout)
+	}
+	c.closed = true
+}
+
+// step executes the next instruction and returns it. If the instruction
+// would block, the computer is left as it was before the call.
+func (c *Computer) step() (instruction, error) {
+	pc := c.nextInst
+	if pc < 0 || pc >= len(c.cells) {
+		return nil, fmt.Errorf("instruction pointer %d out of memory", pc)
+	}
+	h, err := decodeHeader(c.cells[pc])
+	if err != nil {
+		return nil, err
 	}
-	fmt.Fprintf(w, "|")
-	for i := c.nextInst; i < len(c.cells); i++ {
-		fmt.Fprintf(w, "%5d ", c.cells[i])
-		if i%10 == 9 {
-			fmt.Fprintln(w)
+	ins := newInstruction(h.op)
+	ins.parse(parseParameters(c.cells, pc, h))
+	if h.op != opHalt {
+		// A halted program stays at its HALT.
+		c.nextInst += h.size()
+	}
+	if err := ins.run(c); err != nil {
+		c.nextInst = pc
+		if oe, ok := err.(*OverflowError); ok {
+			oe.PC = pc
 		}
+		return nil, err
 	}
-	fmt.Fprintln(w)
+	return ins, nil
+}
+
+// errWouldBlock is returned by non-blocking input and output instructions
+// when their channel is not ready.
+var errWouldBlock = errors.New("operation would block")
+
+// errNeedsInput is returned by input instructions when there are no pending
+// inputs and no stdin to wait on.
+var errNeedsInput = errors.New("needs input")
+
+// ErrInputExhausted is returned when a program needs an input but stdin has
+// been closed.
+var ErrInputExhausted = errors.New("input exhausted")
+
+// ErrMemoryLimit is wrapped by the error of a write that would grow memory
+// past the MaxMemory of a computer.
+var ErrMemoryLimit = errors.New("memory limit exceeded")
 
-	return w.String()
+// DefaultMaxMemory is the number of cells memory may grow to when no other
+// limit is set, which keeps a write to a huge address from exhausting the
+// memory of the process.
+const DefaultMaxMemory = 1 << 24
+
+// memoryLimit returns the number of cells memory may grow to, given the
+// configured maximum.
+func memoryLimit(max int) int {
+	if max > 0 {
+		return max
+	}
+	return DefaultMaxMemory
 }
 
-func (c *Computer) Run() error {
-	for !c.done {
-		if err := c.next(); err != nil {
-			return err
+// errMemoryLimit returns the error of a write to pos past the memory limit.
+func errMemoryLimit(pos int) error {
+	return fmt.Errorf("write to address %d: %w", pos, ErrMemoryLimit)
+}
+
+const (
+	maxInt = int(^uint(0) >> 1)
+	minInt = -maxInt - 1
+)
+
+// An OverflowError is returned by a computer checking overflows when the
+// result of an arithmetic instruction doesn't fit in an int.
+type OverflowError struct {
+	PC   int    // address of the instruction.
+	Op   string // + or *.
+	A, B int    // operands.
+}
+
+func (e *OverflowError) Error() string {
+	return fmt.Sprintf("%d %s %d overflows", e.A, e.Op, e.B)
+}
+
+func addOverflows(a, b int) bool {
+	return (b > 0 && a > maxInt-b) || (b < 0 && a < minInt-b)
+}
+
+func mulOverflows(a, b int) bool {
+	if a == 0 || b == 0 {
+		return false
+	}
+	p := a * b
+	return p/b != a || (a == -1 && b == minInt) || (b == -1 && a == minInt)
+}
+
+func (c *Computer) receive() (int, error) {
+	if c.taint != nil {
+		c.taint.fromStdin = len(c.pending) == 0
+	}
+	if len(c.pending) > 0 {
+		val := c.pending[0]
+		c.pending = c.pending[1:]
+		return val, nil
+	}
+	if c.stdin == nil {
+		return 0, errNeedsInput
+	}
+	if !c.nonBlocking {
+		select {
+		case val, ok := <-c.stdin:
+			return val, inputError(ok)
+		case <-c.ctx.Done():
+			return 0, c.ctx.Err()
 		}
 	}
-	close(c.stdout)
-	return nil
+	select {
+	case val, ok := <-c.stdin:
+		return val, inputError(ok)
+	default:
+		return 0, errWouldBlock
+	}
 }
 
-func (c *Computer) next() error {
-	ins, err := newInstruction(c.cells[c.nextInst])
-	if err != nil {
-		return err
+func inputError(ok bool) error {
+	if !ok {
+		return ErrInputExhausted
 	}
-	ins.parse(c)
-	ins.run(c)
 	return nil
 }
 
-func (c *Computer) read(pos int) int { return c.cells[pos] }
+func (c *Computer) send(val int) error {
+	// Stdout is closed once the program halts, and stepping back past the
+	// halt can't reopen it.
+	if c.stdout == nil || c.closed {
+		return nil
+	}
+	if !c.nonBlocking {
+		select {
+		case c.stdout <- val:
+			return nil
+		case <-c.ctx.Done():
+			return c.ctx.Err()
+		}
+	}
+	select {
+	case c.stdout <- val:
+		return nil
+	default:
+		return errWouldBlock
+	}
+}
 
-func (c *Computer) write(pos, val int) { c.cells[pos] = val }
+// cell returns the value at addr, which is 0 past the end of memory.
+func (c *Computer) cell(addr int) int {
+	if addr < 0 || addr >= len(c.cells) {
+		return 0
+	}
+	return c.cells[addr]
+}
+
+func (c *Computer) read(pos int) (int, error) {
+	if pos < 0 {
+		return 0, fmt.Errorf("read from negative address %d", pos)
+	}
+	val := c.cell(pos)
+	if c.cov != nil && pos < len(c.cov.Reads) && !c.hist.isReplaying() {
+		c.cov.Reads[pos]++
+	}
+	if len(c.stops) > 0 {
+		c.watched(pos, ReadAccess, val)
+	}
+	return val, nil
+}
+
+// write stores val at pos, growing memory if pos is past its end.
+func (c *Computer) write(pos, val int) error {
+	if pos < 0 {
+		return fmt.Errorf("write to negative address %d", pos)
+	}
+	if pos >= len(c.cells) {
+		if pos >= memoryLimit(c.MaxMemory) {
+			return errMemoryLimit(pos)
+		}
+		c.cells = grow(c.cells, pos+1)
+	}
+	if c.hist != nil {
+		c.hist.wrote(pos, c.cells[pos])
+	}
+	if c.cov != nil && pos < len(c.cov.Writes) && !c.hist.isReplaying() {
+		c.cov.Writes[pos]++
+	}
+	if c.tr != nil && !c.hist.isReplaying() {
+		c.tr.wrote(pos, val)
+	}
+	if len(c.stops) > 0 {
+		c.watched(pos, WriteAccess, val)
+	}
+	c.cells[pos] = val
+	return nil
+}
+
+// grow returns cells extended with zeros to hold n cells.
+func grow(cells []int, n int) []int {
+	if n <= cap(cells) {
+		old := len(cells)
+		cells = cells[:n]
+		for i := old; i < n; i++ {
+			cells[i] = 0
+		}
+		return cells
+	}
+	size := 2 * n
+	if size < n {
+		size = n
+	}
+	grown := make([]int, n, size)
+	copy(grown, cells)
+	return grown
+}
 
 func (c *Computer) Stdin() chan<- int  { return c.stdin }
 func (c *Computer) Stdout() <-chan int { return c.stdout }
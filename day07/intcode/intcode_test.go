@@ -0,0 +1,1029 @@
+package intcode
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+// echo reads a value, outputs it twice and halts.
+var echo = []int{3, 0, 4, 0, 4, 0, 99}
+
+func TestSchedulerOrder(t *testing.T) {
+	a, b, c := make(chan int, 1), make(chan int, 1), make(chan int, 4)
+	a <- 42
+	s := NewScheduler(NewComputer(echo, a, b), NewComputer(echo, b, c))
+
+	var trace []string
+	s.Output = func(node, value int) { trace = append(trace, fmt.Sprintf("%d:%d", node, value)) }
+	if err := s.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The second output of the first computer blocks until the second one
+	// reads the first value.
+	want := "[0:42 1:42 1:42 0:42]"
+	if got := fmt.Sprint(trace); got != want {
+		t.Fatalf("expected trace %s; got %s", want, got)
+	}
+}
+
+func TestSchedulerDeadlock(t *testing.T) {
+	s := NewScheduler(NewComputer(echo, make(chan int, 1), make(chan int, 1)))
+	if err := s.Run(); err != ErrDeadlock {
+		t.Fatalf("expected %v; got %v", ErrDeadlock, err)
+	}
+}
+
+func TestRunPipelineFailure(t *testing.T) {
+	bad := NewComputer([]int{1, 0, 0, 0, 42}, make(chan int), make(chan int))
+	blocked := NewComputer(echo, make(chan int), make(chan int))
+
+	err := RunPipeline(context.Background(), bad, blocked)
+	perr, ok := err.(PipelineError)
+	if !ok || len(perr) != 1 {
+		t.Fatalf("expected a single failure; got %v", err)
+	}
+	if perr[0].Node != 0 || perr[0].PC != 4 {
+		t.Fatalf("expected computer 0 to fail at 4; got %v", perr[0])
+	}
+	if _, ok := <-blocked.stdout; ok {
+		t.Fatalf("expected the output of the canceled computer to be closed")
+	}
+}
+
+func TestRunPipelineConnectedFailure(t *testing.T) {
+	// The echo computer reads the output of the failing one, which it closes
+	// when it fails.
+	for i := 0; i < 200; i++ {
+		link := make(chan int)
+		bad := NewComputer([]int{42}, make(chan int), link)
+		echoed := NewComputer(echo, link, make(chan int, 1))
+
+		err := RunPipeline(context.Background(), bad, echoed)
+		perr, ok := err.(PipelineError)
+		if !ok || len(perr) != 1 || perr[0].Node != 0 {
+			t.Fatalf("expected computer 0 to be the only failure; got %v", err)
+		}
+	}
+}
+
+func TestClosedInput(t *testing.T) {
+	in := make(chan int)
+	close(in)
+	c := NewComputer(echo, in, make(chan int, 2))
+	if err := c.Run(); err != ErrInputExhausted {
+		t.Fatalf("expected %v; got %v", ErrInputExhausted, err)
+	}
+
+	c = NewComputer(echo, nil, make(chan int, 2))
+	c.HaltOnEOF = true
+	if err := c.Run(); err != nil {
+		t.Fatalf("expected the computer to halt; got %v", err)
+	}
+}
+
+func TestStep(t *testing.T) {
+	c := NewComputer(echo, nil, nil)
+
+	var events []string
+	for !c.Halted() {
+		ev, err := c.Step()
+		if err != nil {
+			t.Fatal(err)
+		}
+		events = append(events, fmt.Sprintf("%v@%d:%d", ev.Kind, ev.PC, ev.Value))
+		if ev.Kind == NeedsInput {
+			c.Provide(7)
+		}
+	}
+
+	want := "[needs input@0:0 input@0:7 output@2:7 output@4:7 halted@6:0]"
+	if got := fmt.Sprint(events); got != want {
+		t.Fatalf("expected events %s; got %s", want, got)
+	}
+}
+
+// countdown reads n and outputs n, n-1, ..., 1.
+var countdown = append([]int{3, 100, 4, 100, 1001, 100, -1, 100, 1005, 100, 2, 99}, make([]int, 89)...)
+
+func TestStepBack(t *testing.T) {
+	tt := []struct {
+		budget int
+		oldest int // oldest step that can still be reached.
+	}{
+		{budget: 10000, oldest: 0},
+		{budget: 1000, oldest: 0},
+		{budget: 300, oldest: 525},
+	}
+
+	for _, tc := range tt {
+		t.Run(fmt.Sprint(tc.budget), func(t *testing.T) {
+			c := NewComputer(countdown, nil, nil)
+			c.Provide(200)
+			c.RecordHistory(tc.budget)
+
+			var states []string
+			for !c.Halted() {
+				states = append(states, fmt.Sprint(c.PC(), c.Memory()))
+				if _, err := c.Step(); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			for i := len(states) - 1; i >= tc.oldest; i-- {
+				if _, err := c.StepBack(); err != nil {
+					t.Fatalf("step back to %d: %v", i, err)
+				}
+				if got := fmt.Sprint(c.PC(), c.Memory()); got != states[i] {
+					t.Fatalf("expected state at step %d to be %s; got %s", i, states[i], got)
+				}
+			}
+			if _, err := c.StepBack(); err != ErrHistoryStart {
+				t.Fatalf("expected %v; got %v", ErrHistoryStart, err)
+			}
+		})
+	}
+}
+
+func TestStepBackHalt(t *testing.T) {
+	stdout := make(chan int, 2)
+	c := NewComputer([]int{104, 7, 99}, nil, stdout)
+	c.RecordHistory(100)
+	if err := c.Run(); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := c.StepBack(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The output was already sent and stdout closed, so running it again
+	// sends nothing.
+	if ev, err := c.Step(); err != nil || ev.Kind != Output || ev.Value != 7 {
+		t.Fatalf("expected to output 7 again; got %+v, %v", ev, err)
+	}
+	var got []int
+	for v := range stdout {
+		got = append(got, v)
+	}
+	if fmt.Sprint(got) != "[7]" {
+		t.Fatalf("expected a single 7 sent; got %v", got)
+	}
+}
+
+func TestRewindGrownMemory(t *testing.T) {
+	program := []int{1101, 1, 2, 10, 1101, 3, 4, 20, 99}
+	c := NewComputer(program, nil, nil)
+	c.RecordHistory(100)
+	if err := c.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.StepBack(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.StepBack(); err != nil {
+		t.Fatal(err)
+	}
+	if got := len(c.Memory()); got != 11 {
+		t.Fatalf("expected memory to shrink back to 11 cells; got %d", got)
+	}
+	if err := c.Rewind(0); err != nil {
+		t.Fatal(err)
+	}
+	if got := c.Memory(); fmt.Sprint(got) != fmt.Sprint(program) {
+		t.Fatalf("expected memory to be %v after rewinding; got %v", program, got)
+	}
+}
+
+func TestStepBackHooks(t *testing.T) {
+	c := NewComputer(countdown, nil, nil)
+	c.Provide(200)
+	cov := NewCoverage(len(countdown))
+	c.Cover(cov)
+	rec := new(Recording)
+	c.Record(rec)
+	var traced int
+	c.TraceFunc(func(TraceStep) error { traced++; return nil })
+	// A small history makes going back replay from a checkpoint.
+	c.RecordHistory(300)
+	if _, err := c.RunUntil(func(Event) bool { return false }); err != nil {
+		t.Fatal(err)
+	}
+	counts := func() string {
+		var executed, accessed int
+		for i := range cov.Executed {
+			executed += cov.Executed[i]
+			accessed += cov.Reads[i] + cov.Writes[i]
+		}
+		return fmt.Sprintf("%d executed, %d accessed, %d recorded, %d traced", executed, accessed, len(rec.Records), traced)
+	}
+	want := counts()
+
+	for i := 0; i < 50; i++ {
+		if _, err := c.StepBack(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := counts(); got != want {
+		t.Fatalf("expected stepping back to leave %s; got %s", want, got)
+	}
+}
+
+func TestRewindInputs(t *testing.T) {
+	c := NewComputer(countdown, nil, nil)
+	c.Provide(3)
+	c.RecordHistory(1000)
+	if _, err := c.RunUntil(func(Event) bool { return false }); err != nil {
+		t.Fatal(err)
+	}
+
+	// The input consumed by the first step is given back.
+	if err := c.Rewind(0); err != nil {
+		t.Fatal(err)
+	}
+	ev, err := c.RunUntil(func(ev Event) bool { return ev.Kind == Output })
+	if err != nil || ev.Value != 3 {
+		t.Fatalf("expected output 3 after rewinding; got %v, %v", ev, err)
+	}
+}
+
+func TestCondition(t *testing.T) {
+	c := NewComputer(countdown, nil, nil)
+	c.nextInst = 44
+	c.cells[100] = 11
+
+	tt := []struct {
+		text string
+		want bool
+	}{
+		{"mem[100] > 10 && pc == 44", true},
+		{"mem[100] > 11 || pc != 44", false},
+		{"mem[99 + 1] * 2 == 22", true},
+		{"!(pc < 40) && -rb == 0", true},
+		{"mem[0] == 3 && mem[1] % 7 == 2", true},
+		{"1 + 2 * 3 == 7", true},
+	}
+	for _, tc := range tt {
+		t.Run(tc.text, func(t *testing.T) {
+			cond, err := ParseCondition(tc.text)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := cond.Eval(c)
+			if err != nil || got != tc.want {
+				t.Fatalf("expected %v; got %v, %v", tc.want, got, err)
+			}
+		})
+	}
+
+	for text, want := range map[string]string{
+		"mem[-1] == 0":  "negative address -1",
+		"1 / mem[1000]": "division by zero",
+	} {
+		cond, err := ParseCondition(text)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := cond.Eval(c); err == nil || err.Error() != want {
+			t.Errorf("expected %q to fail with %q; got %v", text, want, err)
+		}
+	}
+	cond, err := ParseCondition("mem[1000] == 0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := cond.Eval(c); err != nil || !got {
+		t.Errorf("expected memory past the end to read 0; got %v, %v", got, err)
+	}
+
+	for _, text := range []string{"mem[1", "pc ==", "foo", "1 2", "(pc"} {
+		if _, err := ParseCondition(text); err == nil {
+			t.Errorf("expected %q to fail to parse", text)
+		}
+	}
+}
+
+func TestWatchpoints(t *testing.T) {
+	c := NewComputer(countdown, nil, nil)
+	c.Provide(3)
+	c.RecordHistory(1000)
+	cond, err := ParseCondition("mem[100] == 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bp := c.Break(8, cond)
+
+	ev, err := c.Continue()
+	if err != nil || ev.Breakpoint != bp || c.Memory()[100] != 1 {
+		t.Fatalf("expected to stop at breakpoint %d with 1 in memory; got %+v, %v", bp, ev, err)
+	}
+	c.Delete(bp)
+
+	// Find which instruction last wrote the counter.
+	c.Watch(100, 100, WriteAccess)
+	ev, err = c.ReverseContinue()
+	if err != nil || ev.PC != 4 || len(ev.Watch) != 1 || ev.Watch[0].Value != 1 {
+		t.Fatalf("expected to undo the write of 1 at 4; got %+v, %v", ev, err)
+	}
+}
+
+func TestCoverage(t *testing.T) {
+	c := NewComputer(countdown, nil, nil)
+	c.Provide(2)
+	cov := NewCoverage(len(countdown))
+	c.Cover(cov)
+	if err := c.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := cov.WriteProfile(&buf); err != nil {
+		t.Fatal(err)
+	}
+	read, err := ReadCoverage(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cov.Merge(read); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[int]CoverageStatus{0: Covered, 2: Covered, 4: Covered, 8: Covered, 11: Covered, 100: DataOnly}
+	counts := map[int]int{0: 2, 2: 4, 4: 4, 8: 4, 11: 2}
+	for _, l := range cov.Listing(countdown) {
+		if s, ok := want[l.Addr]; ok && (l.Status != s || (counts[l.Addr] != 0 && l.Count != counts[l.Addr])) {
+			t.Errorf("at %d expected %v with count %d; got %+v", l.Addr, s, counts[l.Addr], l)
+		}
+	}
+}
+
+func TestCoverageShorter(t *testing.T) {
+	// A profile of a shorter build of the program doesn't cover the rest.
+	cov := NewCoverage(4)
+	cov.Executed[0] = 1
+	lines := cov.Listing(countdown)
+	if len(lines) == 0 || lines[0].Status != Covered {
+		t.Fatalf("expected the first instruction to be covered; got %+v", lines)
+	}
+	for _, l := range lines[1:] {
+		if l.Addr >= 4 && l.Status != NotCovered {
+			t.Errorf("expected %d past the profile not to be covered; got %+v", l.Addr, l)
+		}
+	}
+}
+
+func TestReplay(t *testing.T) {
+	c := NewComputer(countdown, nil, nil)
+	c.Provide(3)
+	rec := new(Recording)
+	c.Record(rec)
+	if err := c.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := WriteRecordings(&buf, []*Recording{rec}); err != nil {
+		t.Fatal(err)
+	}
+	recs, err := ReadRecordings(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := recs[0].Replay(countdown); err != nil {
+		t.Fatalf("expected the replay to match; got %v", err)
+	}
+
+	// Changing the jump at 8 makes the program leave the loop one step
+	// later, after the first output.
+	patched := append([]int(nil), countdown...)
+	patched[8] = 1006
+	err = rec.Replay(patched)
+	div, ok := err.(*DivergenceError)
+	if !ok || div.Step != 4 || div.Got == nil || div.Got.Kind != Halted {
+		t.Fatalf("expected the replay to diverge by halting at step 4; got %v", err)
+	}
+}
+
+func TestTrace(t *testing.T) {
+	c := NewComputer(countdown, nil, nil)
+	c.Provide(2)
+	var buf bytes.Buffer
+	if err := c.Trace(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	trace, err := ReadTrace(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(trace.Memory) != len(countdown) || len(trace.Steps) != 8 {
+		t.Fatalf("expected %d cells and 8 steps; got %d and %d", len(countdown), len(trace.Memory), len(trace.Steps))
+	}
+	want := TraceStep{Step: 2, PC: 4, Inst: "ADD @100 = @100 + -1", Kind: Executed, Writes: []TraceWrite{{100, 1}}}
+	if got := trace.Steps[2]; !got.Equal(want) || got.Step != want.Step {
+		t.Fatalf("expected %v; got %v", want, got)
+	}
+}
+
+func TestTaint(t *testing.T) {
+	// Reads a and b, outputs a+a, then b, and then 1 if b is not 0.
+	program := append([]int{3, 20, 3, 21, 1, 20, 20, 22, 4, 22, 4, 21, 1005, 21, 17, 104, 0, 104, 1, 99}, 0, 0, 0)
+	tests := []struct {
+		flow TaintFlow
+		want []Taint
+	}{
+		{DataFlow, []Taint{{"input 0"}, {"input 1"}, nil}},
+		{ControlFlow, []Taint{{"input 0"}, {"input 1"}, {"input 1"}}},
+	}
+	for _, tc := range tests {
+		c := NewComputer(program, nil, nil)
+		c.TrackTaint(tc.flow, nil)
+		c.Provide(3, 4)
+		var got []Taint
+		for !c.Halted() {
+			ev, err := c.Step()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ev.Kind == Output {
+				got = append(got, ev.Taint)
+			}
+		}
+		if fmt.Sprint(got) != fmt.Sprint(tc.want) {
+			t.Errorf("flow %d: expected outputs depending on %v; got %v", tc.flow, tc.want, got)
+		}
+	}
+}
+
+func TestSchedulerTaint(t *testing.T) {
+	// The second computer doubles what it receives from the first one.
+	doubler := []int{3, 9, 1, 9, 9, 9, 4, 9, 99, 0}
+	in, mid, out := make(chan int, 1), make(chan int, 1), make(chan int, 1)
+	in <- 21
+	first := NewComputer(echo, in, mid)
+	second := NewComputer(doubler, mid, out)
+	first.TrackTaint(DataFlow, func(n int) Taint { return Taint{"x"} })
+	second.TrackTaint(DataFlow, nil)
+
+	var got Taint
+	s := NewScheduler(first, second)
+	s.Step = func(node int, ev Event) {
+		if node == 1 && ev.Kind == Output {
+			got = ev.Taint
+		}
+	}
+	if err := s.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(got) != "{x}" {
+		t.Fatalf("expected the output to depend on {x}; got %v", got)
+	}
+}
+
+func TestCheckOverflow(t *testing.T) {
+	tests := []struct {
+		program []int
+		want    *OverflowError
+	}{
+		{[]int{1101, maxInt, 1, 0, 99}, &OverflowError{PC: 0, Op: "+", A: maxInt, B: 1}},
+		{[]int{1101, minInt, -1, 0, 99}, &OverflowError{PC: 0, Op: "+", A: minInt, B: -1}},
+		{[]int{1102, maxInt/2 + 1, 2, 0, 99}, &OverflowError{PC: 0, Op: "*", A: maxInt/2 + 1, B: 2}},
+		{[]int{1102, -1, minInt, 0, 99}, &OverflowError{PC: 0, Op: "*", A: -1, B: minInt}},
+		{[]int{1102, minInt / 4, 4, 0, 99}, nil},
+		{[]int{1101, maxInt, minInt, 0, 99}, nil},
+	}
+	for _, tc := range tests {
+		c := NewComputer(tc.program, nil, nil)
+		c.CheckOverflow = true
+		err := c.Run()
+		if tc.want == nil {
+			if err != nil {
+				t.Errorf("%v: expected no error; got %v", tc.program, err)
+			}
+			continue
+		}
+		if oe, ok := err.(*OverflowError); !ok || *oe != *tc.want || c.PC() != 0 {
+			t.Errorf("%v: expected %#v at 0; got %#v at %d", tc.program, tc.want, err, c.PC())
+		}
+	}
+}
+
+func TestMemory(t *testing.T) {
+	tests := []struct {
+		name    string
+		program []int
+		outputs string
+		size    int // of the memory once the program stops.
+		err     string
+	}{
+		{"grows on writes", []int{109, 10, 21101, 1, 2, 5, 204, 5, 99}, "[3]", 16, ""},
+		{"reads 0 past the end", []int{4, 1000, 99}, "[0]", 3, ""},
+		{"truncated", []int{4}, "[4]", 1, "instruction pointer 2 out of memory"},
+		{"negative read", []int{4, -1, 99}, "[]", 3, "read from negative address -1"},
+		{"negative write", []int{109, -5, 21101, 1, 2, 0, 99}, "[]", 7, "write to negative address -5"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := NewComputer(test.program, nil, nil)
+			outputs, err := collectOutputs(c)
+			if (err == nil && test.err != "") || (err != nil && err.Error() != test.err) {
+				t.Fatalf("expected error %q; got %v", test.err, err)
+			}
+			if fmt.Sprint(outputs) != test.outputs {
+				t.Fatalf("expected outputs %s; got %v", test.outputs, outputs)
+			}
+			if len(c.Memory()) != test.size {
+				t.Fatalf("expected %d cells of memory; got %d", test.size, len(c.Memory()))
+			}
+		})
+	}
+
+	c := NewComputer([]int{1101, 1, 1, 100, 99}, nil, nil)
+	c.MaxMemory = 100
+	if err := c.Run(); !errors.Is(err, ErrMemoryLimit) || c.PC() != 0 || len(c.Memory()) != 5 {
+		t.Fatalf("expected to exceed the memory limit at 0; got %v at %d", err, c.PC())
+	}
+}
+
+func TestEvalHugeAddresses(t *testing.T) {
+	for _, dest := range []int{1 << 62, maxInt} {
+		program := []int{1101, 1, 2, dest, 99}
+		want := fmt.Sprintf("at 0: write to address %d: memory limit exceeded", dest)
+		if _, err := Eval(program); err == nil || err.Error() != want {
+			t.Errorf("expected Eval to fail with %q; got %v", want, err)
+		}
+		f := NewFunc(program)
+		if !f.Compiled() {
+			t.Fatalf("expected %v to compile", program)
+		}
+		if _, err := f.Call(); err == nil || err.Error() != want {
+			t.Errorf("expected the compiled call to fail with %q; got %v", want, err)
+		}
+	}
+}
+
+func TestBigComputer(t *testing.T) {
+	// Reads n and outputs n*n, forever.
+	program, err := ParseBig("3,11,2,11,11,12,4,12,1105,1,0,0,0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdin, stdout := make(chan *big.Int, 2), make(chan *big.Int, 2)
+	n, _ := new(big.Int).SetString("123456789012345678901234567890", 10)
+	stdin <- n
+	stdin <- big.NewInt(3)
+	close(stdin)
+	c := NewBigComputer(program, stdin, stdout)
+	c.HaltOnEOF = true
+
+	var got []string
+	done := make(chan error)
+	go func() { done <- c.Run() }()
+	for v := range stdout {
+		got = append(got, v.String())
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if want := "[15241578753238836750495351562536198787501905199875019052100 9]"; fmt.Sprint(got) != want {
+		t.Fatalf("expected %s; got %v", want, got)
+	}
+}
+
+// TestComputersAgree checks that a Computer and a BigComputer decode and run
+// instructions alike, including the ones that fail.
+func TestComputersAgree(t *testing.T) {
+	tests := []struct {
+		program string
+		outputs string
+		err     string
+	}{
+		{"109,10,21101,1,2,5,204,5,99", "[3]", "<nil>"},
+		{"4,1000,99", "[0]", "<nil>"},
+		{"11101,1,1,3,99", "[]", "wrote into an immediate parameter"},
+		{"103,1,99", "[]", "wrote into an immediate parameter"},
+		{"301,0,0,0,99", "[]", "unknown mode 3 for parameter 1"},
+		{"42", "[]", "unknown op code 42"},
+		{"4,-1,99", "[]", "read from negative address -1"},
+		{"109,-5,21101,1,2,0,99", "[]", "write to negative address -5"},
+		{"1105,1,7,99", "[]", "instruction pointer 7 out of memory"},
+		{"1101,1,2,4611686018427387904,99", "[]", "write to address 4611686018427387904: memory limit exceeded"},
+		{"1101,1,2,9223372036854775807,99", "[]", "write to address 9223372036854775807: memory limit exceeded"},
+		{"3,9223372036854775807,99", "[]", "write to address 9223372036854775807: memory limit exceeded"},
+	}
+	for _, test := range tests {
+		t.Run(test.program, func(t *testing.T) {
+			program, err := Parse(test.program)
+			if err != nil {
+				t.Fatal(err)
+			}
+			c := NewComputer(program, nil, nil)
+			outputs, err := collectOutputs(c, 5)
+			want := test.outputs + " " + test.err
+			if got := fmt.Sprintf("%v %v", outputs, err); got != want {
+				t.Errorf("computer: expected %s; got %s", want, got)
+			}
+
+			bigProgram, _ := ParseBig(test.program)
+			bc := NewBigComputer(bigProgram, nil, nil)
+			bc.Provide(big.NewInt(5))
+			var bigOutputs []int
+			for !bc.Halted() {
+				var ev BigEvent
+				if ev, err = bc.Step(); err != nil {
+					break
+				}
+				if ev.Kind == Output {
+					bigOutputs = append(bigOutputs, int(ev.Value.Int64()))
+				}
+			}
+			if got := fmt.Sprintf("%v %v", bigOutputs, err); got != want {
+				t.Errorf("big computer: expected %s; got %s", want, got)
+			}
+		})
+	}
+}
+
+func TestImage(t *testing.T) {
+	im := &Image{
+		Program: []int{3, 100, 4, 100, 1001, 100, -1, 100, 1005, 100, 2, 99, minInt, maxInt},
+		Symbols: Symbols{100: "counter", 2: "loop"},
+		Source:  []SourceLine{{0, "countdown.ic", 1}, {2, "my programs/countdown.ic", 2}},
+		Inputs:  []NamedInput{{"three", []int{3}}, {"none", nil}},
+	}
+	var text, bin bytes.Buffer
+	if err := im.WriteText(&text); err != nil {
+		t.Fatal(err)
+	}
+	if err := EncodeImage(&bin, im); err != nil {
+		t.Fatal(err)
+	}
+	if bin.Len() >= text.Len() {
+		t.Errorf("expected the binary image to be smaller than %d bytes; got %d", text.Len(), bin.Len())
+	}
+
+	for _, data := range [][]byte{text.Bytes(), bin.Bytes()} {
+		got, err := Load(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fmt.Sprint(got) != fmt.Sprint(im) {
+			t.Errorf("expected %v; got %v", im, got)
+		}
+	}
+
+	if _, err := Load(bin.Bytes()[:bin.Len()-1]); err == nil {
+		t.Errorf("expected a truncated image to fail to load")
+	}
+
+	inst, _, err := im.Symbols.Disassemble(im.Program, 8)
+	if want := "JumpIf(true) @counter loop"; err != nil || inst != want {
+		t.Errorf("expected %q; got %q, %v", want, inst, err)
+	}
+}
+
+func TestDump(t *testing.T) {
+	c := NewComputer(countdown, nil, nil)
+	c.Provide(3)
+	before := c.Snapshot()
+	if _, err := c.RunUntil(func(ev Event) bool { return ev.Kind == Output }); err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := Diff(before, c); len(diff) != 1 || diff[0] != (CellDiff{100, 0, 3}) {
+		t.Fatalf("expected only the counter to change from 0 to 3; got %v", diff)
+	}
+
+	var b strings.Builder
+	opts := DumpOptions{Width: 4, Window: 1, Since: before, Symbols: Symbols{100: "counter"}}
+	if err := c.Dump(&b, opts); err != nil {
+		t.Fatal(err)
+	}
+	want := `pc 4, rb 0: ADD @counter = @counter + -1
+     0:       3     100       4     100
+     4: >  1001     100      -1     100
+     8:    1005     100       2      99
+   ...
+   100: *     3  ; counter@100
+`
+	if b.String() != want {
+		t.Fatalf("expected dump:\n%s\ngot:\n%s", want, b.String())
+	}
+}
+
+func TestFunc(t *testing.T) {
+	f := NewFunc(countdown)
+	f.Memory = true
+
+	// Calls share nothing, so they can run concurrently.
+	results := make([]Result, 10)
+	errs := make(chan error, len(results))
+	for i := range results {
+		go func(i int) {
+			var err error
+			results[i], err = f.Call(i + 1)
+			errs <- err
+		}(i)
+	}
+	for range results {
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i, res := range results {
+		n := i + 1
+		if len(res.Outputs) != n || res.Outputs[0] != n || res.Outputs[n-1] != 1 {
+			t.Errorf("expected %d outputs counting down from %d; got %v", n, n, res.Outputs)
+		}
+		// One input, then an output, an addition and a jump per value, and
+		// the final halt.
+		if res.Steps != 3*n+2 || res.Memory[100] != 0 {
+			t.Errorf("expected %d steps ending with 0 at 100; got %d steps and %d", 3*n+2, res.Steps, res.Memory[100])
+		}
+	}
+
+	if _, err := f.Call(); !errors.Is(err, ErrInputExhausted) || err.Error() != "at 0: input exhausted" {
+		t.Errorf("expected the call to fail with %v at 0; got %v", ErrInputExhausted, err)
+	}
+	outputs, err := Eval(echo, 7)
+	if err != nil || fmt.Sprint(outputs) != "[7 7]" {
+		t.Errorf("expected the echo to output [7 7]; got %v, %v", outputs, err)
+	}
+}
+
+func TestReset(t *testing.T) {
+	c := NewComputer(countdown, nil, nil)
+	c.HaltOnEOF = true
+	c.Break(4, nil)
+	c.Provide(3)
+	if _, err := c.RunUntil(func(Event) bool { return false }); err != nil {
+		t.Fatal(err)
+	}
+
+	// The memory of the countdown is large enough to hold the echo.
+	mem := &c.Memory()[0]
+	c.Reset(echo)
+	if &c.Memory()[0] != mem || len(c.Memory()) != len(echo) || c.PC() != 0 || c.Halted() || c.HaltOnEOF || len(c.Breakpoints()) > 0 {
+		t.Fatalf("expected a fresh computer reusing its memory; got %v", c)
+	}
+	outputs, err := collectOutputs(c, 5)
+	if err != nil || fmt.Sprint(outputs) != "[5 5]" {
+		t.Fatalf("expected the echo to output [5 5]; got %v, %v", outputs, err)
+	}
+
+	var pool Pool
+	pool.Put(c)
+	in, out := make(chan int, 1), make(chan int, 2)
+	in <- 8
+	c = pool.Get(echo, in, out)
+	if err := c.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if a, b := <-out, <-out; a != 8 || b != 8 {
+		t.Fatalf("expected the pooled echo to output 8 twice; got %d and %d", a, b)
+	}
+}
+
+// collectOutputs provides the inputs to c and returns its outputs once it
+// halts.
+func collectOutputs(c *Computer, inputs ...int) ([]int, error) {
+	c.Provide(inputs...)
+	var outputs []int
+	for !c.Halted() {
+		ev, err := c.Step()
+		if err != nil {
+			return outputs, err
+		}
+		if ev.Kind == Output {
+			outputs = append(outputs, ev.Value)
+		}
+	}
+	return outputs, nil
+}
+
+func TestCache(t *testing.T) {
+	f := NewFunc(countdown)
+	f.Cache = NewCache(2)
+
+	res, err := f.Call(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Changing a result doesn't change the cached one.
+	res.Outputs[0] = 42
+	if res, err := f.Call(3); err != nil || fmt.Sprint(res.Outputs) != "[3 2 1]" || res.Steps != 11 {
+		t.Fatalf("expected the cached outputs [3 2 1] in 11 steps; got %v in %d, %v", res.Outputs, res.Steps, err)
+	}
+
+	// Failures are cached, but not cancellations.
+	for i := 0; i < 2; i++ {
+		if _, err := f.Call(); !errors.Is(err, ErrInputExhausted) {
+			t.Fatalf("expected %v; got %v", ErrInputExhausted, err)
+		}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := f.CallContext(ctx, 4); err != context.Canceled {
+		t.Fatalf("expected %v; got %v", context.Canceled, err)
+	}
+
+	// The results of 3 and no input are in the cache, so 4 evicts 3.
+	f.Call(4)
+	f.Call(3)
+	want := CacheStats{Hits: 2, Misses: 5, Evictions: 2, Entries: 2}
+	if got := f.Cache.Stats(); got != want {
+		t.Fatalf("expected stats %+v; got %+v", want, got)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	program := []int{
+		1, 9, 9, 4, // 0: writes into the next instruction.
+		1105, 0, 100, // 4: never jumps.
+		1006, 20, 100, // 7: may jump outside of the program.
+		1005, 20, 17, // 10
+		11101, 1, 1, 20, // 13: writes through an immediate parameter.
+		42, // 17: unknown instruction.
+		0, 0, 0,
+	}
+	want := []string{
+		"0: self-modifying: writes into the instruction at 4",
+		"7: jump outside: jumps to 100, outside of the program",
+		"13: immediate write: writes through an immediate mode parameter",
+		"17: invalid instruction: unknown op code 42",
+	}
+	var got []string
+	for _, w := range Verify(program) {
+		got = append(got, w.String())
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("expected warnings:\n%s\ngot:\n%s", strings.Join(want, "\n"), strings.Join(got, "\n"))
+	}
+
+	if warnings := Verify(countdown); len(warnings) > 0 {
+		t.Fatalf("expected no warnings for the countdown; got %v", warnings)
+	}
+}
+
+func TestOptimize(t *testing.T) {
+	program := []int{
+		1101, 0, 5, 23, // 0: ADD @23 = 0 + 5 is a move of 5.
+		1002, 23, 1, 24, // 4: MUL @24 = @23 * 1 is a move of @23.
+		1001, 24, 0, 24, // 8: ADD @24 = @24 + 0 does nothing.
+		1106, 0, 20, // 12: always jumps.
+		1107, 2, 3, 25, // 15: LessThan @25 = 2 < 3 is a move of 1, but unreachable.
+		99,    // 19
+		4, 24, // 20
+		99, // 22
+		0, 0, 0,
+	}
+	code := compile(program, false)
+	if code == nil {
+		t.Fatalf("expected the program to compile")
+	}
+	want := map[int]compiledOp{
+		0:  {op: opMove, a: parameter{value: 5, mode: immediateMode}, dest: parameter{value: 23}, next: 4},
+		4:  {op: opMove, a: parameter{value: 23}, dest: parameter{value: 24}, next: 8},
+		8:  {op: opNop, next: 12},
+		12: {op: opJump, b: parameter{value: 20, mode: immediateMode}, next: 15},
+		20: {op: opOutput, a: parameter{value: 24}, next: 22},
+		22: {op: opHalt, next: 23},
+	}
+	for addr, op := range code {
+		if op != want[addr] {
+			t.Errorf("expected %+v at %d; got %+v", want[addr], addr, op)
+		}
+	}
+	if res, err := NewFunc(program).Call(); err != nil || fmt.Sprint(res.Outputs) != "[5]" {
+		t.Errorf("expected the program to output 5; got %v, %v", res.Outputs, err)
+	}
+
+	// A program writing into its code isn't compiled.
+	if compile([]int{1101, 1, 1, 0, 99}, false) != nil {
+		t.Errorf("expected a self-modifying program not to compile")
+	}
+}
+
+// TestOptimizeDifferential checks that random programs have the same effects
+// when compiled as when interpreted.
+func TestOptimizeDifferential(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 500; i++ {
+		program, inputs := randomProgram(r)
+		haltOnEOF, checkOverflow := r.Intn(2) == 0, r.Intn(2) == 0
+
+		f := NewFunc(program)
+		f.Memory, f.HaltOnEOF, f.CheckOverflow = true, haltOnEOF, checkOverflow
+		if !f.Compiled() {
+			t.Fatalf("expected %v to compile; got warnings %v", program, Verify(program))
+		}
+		got, gotErr := f.Call(inputs...)
+
+		c := NewComputer(program, nil, nil)
+		c.HaltOnEOF, c.CheckOverflow = haltOnEOF, checkOverflow
+		c.Provide(inputs...)
+		var want Result
+		wantErr := new(Func).run(context.Background(), c, &want)
+		want.Memory = c.Memory()
+
+		if fmt.Sprint(got, gotErr) != fmt.Sprint(want, wantErr) {
+			t.Fatalf("program %v with inputs %v:\ncompiled:    %v, %v\ninterpreted: %v, %v", program, inputs, got, gotErr, want, wantErr)
+		}
+	}
+}
+
+// randomProgram returns a program made of arithmetic, comparisons, inputs,
+// outputs, relative base moves and forward jumps, which always terminates,
+// and some inputs for it, sometimes fewer than it reads. It works on a few
+// data cells following the code and as many past the end of the program,
+// which grow its memory, and sometimes reads and writes negative addresses.
+func randomProgram(r *rand.Rand) ([]int, []int) {
+	const dataSize = 8
+	ops := make([]opCode, 1+r.Intn(30))
+	sizes := map[opCode]int{opAdd: 4, opMult: 4, opLessThan: 4, opEquals: 4, opInput: 2, opOutput: 2, opJumpIfTrue: 3, opJumpIfFalse: 3, opRelBase: 2}
+	kinds := []opCode{opAdd, opMult, opLessThan, opEquals, opInput, opOutput, opJumpIfTrue, opJumpIfFalse, opRelBase}
+	starts := make([]int, len(ops)+1)
+	for i := range ops {
+		ops[i] = kinds[r.Intn(len(kinds))]
+		starts[i+1] = starts[i] + sizes[ops[i]]
+	}
+	halt := starts[len(ops)]
+	data := halt + 1
+
+	cell := func() int {
+		if r.Intn(20) == 0 {
+			return -1
+		}
+		return data + r.Intn(2*dataSize)
+	}
+	var program []int
+	param := func(code *int, n int, mode paramMode, v int) {
+		*code += int(mode) * []int{100, 1000, 10000}[n]
+		program = append(program, v)
+	}
+	source := func(code *int, n int) {
+		switch r.Intn(3) {
+		case 0:
+			values := []int{0, 1, -1, 2, 1 << 40, r.Intn(100)}
+			param(code, n, immediateMode, values[r.Intn(len(values))])
+		case 1:
+			param(code, n, positionMode, cell())
+		case 2:
+			param(code, n, relativeMode, data+r.Intn(2*dataSize))
+		}
+	}
+
+	inputs := 0
+	for i, op := range ops {
+		at := len(program)
+		program = append(program, 0)
+		code := int(op)
+		switch op {
+		case opAdd, opMult, opLessThan, opEquals:
+			source(&code, 0)
+			source(&code, 1)
+			dest := cell()
+			if r.Intn(4) == 0 && code/100%10 == 0 {
+				dest = program[at+1]
+			}
+			param(&code, 2, positionMode, dest)
+		case opInput:
+			inputs++
+			param(&code, 0, positionMode, cell())
+		case opOutput:
+			source(&code, 0)
+		case opJumpIfTrue, opJumpIfFalse:
+			source(&code, 0)
+			target := halt
+			if later := len(ops) - i - 1; later > 0 {
+				target = starts[i+1+r.Intn(later+1)]
+			}
+			param(&code, 1, immediateMode, target)
+		case opRelBase:
+			param(&code, 0, immediateMode, r.Intn(2*dataSize)-dataSize)
+		}
+		program[at] = code
+	}
+	program = append(program, 99)
+	for i := 0; i < dataSize; i++ {
+		program = append(program, r.Intn(10)-5)
+	}
+
+	if inputs > 0 {
+		inputs -= r.Intn(2)
+	}
+	in := make([]int, inputs)
+	for i := range in {
+		in[i] = r.Intn(1000) - 500
+	}
+	return program, in
+}
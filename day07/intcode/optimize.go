@@ -0,0 +1,257 @@
+package intcode
+
+import (
+	"context"
+	"fmt"
+)
+
+// Pseudo op codes, produced by the optimizer in place of real instructions
+// with the same effect.
+const (
+	opMove opCode = 100 + iota // dest = a
+	opJump                     // unconditional jump to b
+	opNop
+)
+
+// A compiledOp is an instruction decoded once, ahead of running a program.
+// Jumps have their condition in a and their target in b, and instructions
+// with a single parameter have it in a, or in dest if they write to it.
+type compiledOp struct {
+	op      opCode
+	a, b    parameter
+	dest    parameter
+	next    int // address of the following instruction.
+	checked bool
+}
+
+// compile decodes the code of program once, and optimizes it, if the
+// verifier proves that the code never changes: every instruction that can run
+// is reachable through constant jumps, and no write can reach them, since all
+// of them are to constant addresses outside of the code. It returns nil
+// otherwise, or if the verifier finds any other problem.
+//
+// The result is indexed by address, and only holds the instructions that can
+// run.
+func compile(program []int, checkOverflow bool) []compiledOp {
+	a := analyze(program)
+	if len(a.warnings) > 0 || a.dynamicJumps || a.relativeWrites {
+		return nil
+	}
+	code := make([]compiledOp, len(program))
+	for addr := range a.insts {
+		ins, next, _ := decode(program, addr)
+		op := compiledOp{next: next, checked: checkOverflow}
+		switch ins := ins.(type) {
+		case *addInstruction:
+			op.op, op.a, op.b, op.dest = opAdd, ins.src1, ins.src2, ins.dest
+		case *multInstruction:
+			op.op, op.a, op.b, op.dest = opMult, ins.src1, ins.src2, ins.dest
+		case *lessThanInstruction:
+			op.op, op.a, op.b, op.dest = opLessThan, ins.src1, ins.src2, ins.dest
+		case *equalsInstruction:
+			op.op, op.a, op.b, op.dest = opEquals, ins.src1, ins.src2, ins.dest
+		case *inputInstruction:
+			op.op, op.dest = opInput, ins.arg
+		case *outputInstruction:
+			op.op, op.a = opOutput, ins.arg
+		case *condJumpInstruction:
+			op.op, op.a, op.b = opJumpIfFalse, ins.cond, ins.target
+			if ins.jumpOn {
+				op.op = opJumpIfTrue
+			}
+		case *relBaseInstruction:
+			op.op, op.a = opRelBase, ins.arg
+		case *haltInstruction:
+			op.op = opHalt
+		}
+		code[addr] = op.optimize(len(program))
+	}
+	return code
+}
+
+// optimize returns a simpler instruction with the same effect as op, if it
+// matches one of the patterns of the peephole optimizer:
+//
+//   - operations on immediate values are folded into moves of their result,
+//     unless they overflow and overflows are checked.
+//   - additions of 0 and multiplications by 1 are moves, and multiplications
+//     by 0 are moves of 0. Moving a value to where it is is a no-op, if it is
+//     in the program, of the given size, so the move doesn't grow memory.
+//   - jumps on immediate conditions always jump or never do.
+//
+// Reading memory has no side effects, so operands can be dropped, as long as
+// they are not at negative addresses, which fail.
+func (op compiledOp) optimize(size int) compiledOp {
+	a, b := op.a, op.b
+	isImm := func(p parameter, v int) bool { return p.mode == immediateMode && p.value == v }
+	// safe reports whether reading p can't fail, wherever the relative base
+	// is.
+	safe := func(p parameter) bool {
+		return p.mode == immediateMode || p.mode == positionMode && p.value >= 0
+	}
+	move := func(src parameter) compiledOp {
+		if src.mode == op.dest.mode && src.value == op.dest.value && src.mode == positionMode && src.value >= 0 && src.value < size {
+			return compiledOp{op: opNop, next: op.next}
+		}
+		return compiledOp{op: opMove, a: src, dest: op.dest, next: op.next}
+	}
+	imm := func(v int) parameter { return parameter{value: v, mode: immediateMode} }
+	bothImm := a.mode == immediateMode && b.mode == immediateMode
+
+	switch op.op {
+	case opAdd:
+		switch {
+		case bothImm && !(op.checked && addOverflows(a.value, b.value)):
+			return move(imm(a.value + b.value))
+		case isImm(a, 0):
+			return move(b)
+		case isImm(b, 0):
+			return move(a)
+		}
+	case opMult:
+		switch {
+		case isImm(a, 0) && safe(b) || isImm(b, 0) && safe(a):
+			return move(imm(0))
+		case bothImm && !(op.checked && mulOverflows(a.value, b.value)):
+			return move(imm(a.value * b.value))
+		case isImm(a, 1):
+			return move(b)
+		case isImm(b, 1):
+			return move(a)
+		}
+	case opLessThan:
+		if bothImm {
+			return move(imm(boolToInt(a.value < b.value)))
+		}
+	case opEquals:
+		if bothImm {
+			return move(imm(boolToInt(a.value == b.value)))
+		}
+	case opJumpIfTrue, opJumpIfFalse:
+		if a.mode == immediateMode {
+			if (a.value != 0) == (op.op == opJumpIfTrue) {
+				return compiledOp{op: opJump, b: b, next: op.next}
+			}
+			return compiledOp{op: opNop, next: op.next}
+		}
+	}
+	return op
+}
+
+// load returns the value of p, reading 0 past the end of mem.
+func (p parameter) load(mem []int, relBase int) (int, error) {
+	if p.mode == immediateMode {
+		return p.value, nil
+	}
+	addr := p.addr(relBase)
+	switch {
+	case addr < 0:
+		return 0, fmt.Errorf("read from negative address %d", addr)
+	case addr >= len(mem):
+		return 0, nil
+	}
+	return mem[addr], nil
+}
+
+// store sets the cell p refers to, growing mem up to DefaultMaxMemory cells
+// if it is past its end, and returns mem.
+func (p parameter) store(mem []int, relBase, val int) ([]int, error) {
+	addr := p.addr(relBase)
+	if addr < 0 {
+		return mem, fmt.Errorf("write to negative address %d", addr)
+	}
+	if addr >= len(mem) {
+		if addr >= DefaultMaxMemory {
+			return mem, errMemoryLimit(addr)
+		}
+		mem = grow(mem, addr+1)
+	}
+	mem[addr] = val
+	return mem, nil
+}
+
+// runCompiled runs the compiled code of the Func on the memory of c with the
+// given inputs, with the same effects as running it on c.
+func (f *Func) runCompiled(ctx context.Context, c *Computer, inputs []int, res *Result) (err error) {
+	mem := c.cells
+	defer func() { c.cells = mem }()
+
+	pc, rb := 0, 0
+	fail := func(err error) error { return fmt.Errorf("at %d: %w", pc, err) }
+	for n := 0; ; n++ {
+		if n%1024 == 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			default:
+			}
+		}
+
+		op := &f.code[pc]
+		next := op.next
+		var a, b int
+		switch op.op {
+		case opMove, opAdd, opMult, opLessThan, opEquals, opOutput, opJumpIfTrue, opJumpIfFalse, opRelBase:
+			if a, err = op.a.load(mem, rb); err != nil {
+				return fail(err)
+			}
+		}
+		switch op.op {
+		case opAdd, opMult, opLessThan, opEquals:
+			if b, err = op.b.load(mem, rb); err != nil {
+				return fail(err)
+			}
+		}
+
+		switch op.op {
+		case opMove:
+			mem, err = op.dest.store(mem, rb, a)
+		case opAdd:
+			if op.checked && addOverflows(a, b) {
+				return fail(&OverflowError{PC: pc, Op: "+", A: a, B: b})
+			}
+			mem, err = op.dest.store(mem, rb, a+b)
+		case opMult:
+			if op.checked && mulOverflows(a, b) {
+				return fail(&OverflowError{PC: pc, Op: "*", A: a, B: b})
+			}
+			mem, err = op.dest.store(mem, rb, a*b)
+		case opLessThan:
+			mem, err = op.dest.store(mem, rb, boolToInt(a < b))
+		case opEquals:
+			mem, err = op.dest.store(mem, rb, boolToInt(a == b))
+		case opInput:
+			if len(inputs) == 0 {
+				if f.HaltOnEOF {
+					return nil
+				}
+				return fail(ErrInputExhausted)
+			}
+			if mem, err = op.dest.store(mem, rb, inputs[0]); err == nil {
+				inputs = inputs[1:]
+			}
+		case opOutput:
+			res.Outputs = append(res.Outputs, a)
+		case opJumpIfTrue:
+			if a != 0 {
+				next = op.b.value
+			}
+		case opJumpIfFalse:
+			if a == 0 {
+				next = op.b.value
+			}
+		case opJump:
+			next = op.b.value
+		case opRelBase:
+			rb += a
+		case opHalt:
+			res.Steps++
+			return nil
+		}
+		if err != nil {
+			return fail(err)
+		}
+		res.Steps++
+		pc = next
+	}
+}
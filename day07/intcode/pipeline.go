@@ -0,0 +1,94 @@
+package intcode
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// A NodeError reports the failure of one of several computers running
+// together.
+type NodeError struct {
+	Node int // index of the failing computer.
+	PC   int // address of the instruction that failed.
+	Err  error
+}
+
+func (e *NodeError) Error() string {
+	return fmt.Sprintf("computer %d failed at %d: %v", e.Node, e.PC, e.Err)
+}
+
+func (e *NodeError) Unwrap() error { return e.Err }
+
+// A PipelineError lists every computer that failed in a pipeline, excluding
+// the ones that were only stopped because of those failures.
+type PipelineError []*NodeError
+
+func (e PipelineError) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// RunPipeline runs each of the computers on its own goroutine and waits for
+// all of them to stop. As soon as one of them fails, the rest are canceled so
+// that none is left blocked on a channel, and the returned PipelineError names
+// the computers that caused it. A computer failing with ErrInputExhausted
+// because its stdin is the stdout of a failed computer, which closed it, was
+// only stopped too. If ctx is canceled, its error is returned.
+func RunPipeline(ctx context.Context, computers ...*Computer) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		mu       sync.Mutex
+		failures PipelineError
+		wg       sync.WaitGroup
+	)
+	wg.Add(len(computers))
+	for i, c := range computers {
+		go func(i int, c *Computer) {
+			defer wg.Done()
+			err := c.RunContext(ctx)
+			if err == nil || err == ctx.Err() {
+				return
+			}
+			mu.Lock()
+			failures = append(failures, &NodeError{Node: i, PC: c.nextInst, Err: err})
+			mu.Unlock()
+			cancel()
+		}(i, c)
+	}
+	wg.Wait()
+
+	failures = causes(computers, failures)
+	if len(failures) > 0 {
+		sort.Slice(failures, func(i, j int) bool { return failures[i].Node < failures[j].Node })
+		return failures
+	}
+	return ctx.Err()
+}
+
+// causes returns the failures which were not caused by another one.
+func causes(computers []*Computer, failures PipelineError) PipelineError {
+	closed := make(map[chan int]bool)
+	for _, f := range failures {
+		if out := computers[f.Node].stdout; out != nil {
+			closed[out] = true
+		}
+	}
+	var res PipelineError
+	for _, f := range failures {
+		in := computers[f.Node].stdin
+		if in != nil && closed[in] && errors.Is(f.Err, ErrInputExhausted) {
+			continue
+		}
+		res = append(res, f)
+	}
+	return res
+}
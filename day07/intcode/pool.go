@@ -0,0 +1,31 @@
+package intcode
+
+import "sync"
+
+// A Pool keeps computers for reuse, saving the allocation of their memory
+// when many programs are run one after the other, as in searches. It is safe
+// for concurrent use, and its zero value is ready to use.
+type Pool struct {
+	p sync.Pool
+}
+
+// Get returns a computer running a copy of program with the given channels,
+// as NewComputer does, reusing a computer from the pool if there is one.
+func (p *Pool) Get(program []int, stdin, stdout chan int) *Computer {
+	c, _ := p.p.Get().(*Computer)
+	if c == nil {
+		return NewComputer(program, stdin, stdout)
+	}
+	c.Reset(program)
+	c.stdin, c.stdout = stdin, stdout
+	return c
+}
+
+// Put puts a computer back into the pool. It must no longer be used, neither
+// by the caller nor by any goroutine or scheduler still holding it.
+func (p *Pool) Put(c *Computer) {
+	// Forget the program and whatever the computer was tracking right
+	// away, rather than when it is reused.
+	c.Reset(nil)
+	p.p.Put(c)
+}
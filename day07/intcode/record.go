@@ -0,0 +1,188 @@
+package intcode
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// An IORecord is an input consumed, an output produced, or the halt of a
+// computer, along with the number of instructions it had executed before.
+type IORecord struct {
+	Step  int
+	Kind  EventKind // Input, Output or Halted.
+	Value int       // zero for Halted.
+}
+
+func (r IORecord) String() string {
+	if r.Kind == Executed || r.Kind == Halted {
+		return fmt.Sprintf("%v at step %d", r.Kind, r.Step)
+	}
+	return fmt.Sprintf("%v %d at step %d", r.Kind, r.Value, r.Step)
+}
+
+// A Recording holds the inputs and outputs of a computer, in the order they
+// happened. It is enough to replay the execution of that computer alone,
+// without whatever it was connected to.
+type Recording struct {
+	Records []IORecord
+
+	// steps counts the instructions executed while recording.
+	steps int
+}
+
+// Record makes the computer append to rec every input it consumes, every
+// output it produces, and its halt. Only steps run forward are recorded, so
+// a computer going back in its history must not be recording.
+func (c *Computer) Record(rec *Recording) { c.rec = rec }
+
+// record is called by Step with every event of the recording computer.
+func (rec *Recording) record(ev Event) {
+	switch ev.Kind {
+	case NeedsInput:
+		return
+	case Input, Output, Halted:
+		rec.Records = append(rec.Records, IORecord{Step: rec.steps, Kind: ev.Kind, Value: ev.Value})
+	}
+	rec.steps++
+}
+
+// A DivergenceError is returned by Replay when the execution differs from the
+// recording.
+type DivergenceError struct {
+	Step int
+	Want *IORecord
+	Got  *IORecord // nil if the program waited for an input instead.
+}
+
+func (e *DivergenceError) Error() string {
+	if e.Got == nil {
+		return fmt.Sprintf("replay diverged at step %d: expected %v, but the program needs an input", e.Step, e.Want)
+	}
+	return fmt.Sprintf("replay diverged at step %d: expected %v, got %v", e.Step, e.Want, e.Got)
+}
+
+// Replay runs the program again, feeding it the recorded inputs, and checks
+// that it consumes and produces the same values at the same steps. It returns
+// a *DivergenceError describing the first mismatch, if any, or the error the
+// program failed with.
+//
+// If the recording ends without the program halting, as when the recorded
+// computer was stopped or failed, the replay succeeds once every record has
+// been matched and the program needs more input, produces more output or
+// halts.
+func (rec *Recording) Replay(program []int) error {
+	c := NewComputer(program, nil, nil)
+	records := rec.Records
+	for step := 0; ; {
+		ev, err := c.Step()
+		if err != nil {
+			return fmt.Errorf("replay failed at step %d: %v", step, err)
+		}
+
+		var want *IORecord
+		if len(records) > 0 {
+			want = &records[0]
+		}
+		if ev.Kind == NeedsInput {
+			if want != nil && want.Kind == Input {
+				c.Provide(want.Value)
+				continue
+			}
+			// The recorded computer may have halted on the end of its input.
+			if want == nil || (want.Kind == Halted && want.Step == step && len(records) == 1) {
+				return nil
+			}
+			return &DivergenceError{Step: step, Want: want}
+		}
+
+		if ev.Kind == Executed && (want == nil || want.Step > step) {
+			step++
+			continue
+		}
+		if want == nil {
+			return nil
+		}
+		got := &IORecord{Step: step, Kind: ev.Kind, Value: ev.Value}
+		if *got != *want {
+			return &DivergenceError{Step: step, Want: want, Got: got}
+		}
+		records = records[1:]
+		if ev.Kind == Halted {
+			if len(records) > 0 {
+				return &DivergenceError{Step: step, Want: &records[0], Got: got}
+			}
+			return nil
+		}
+		step++
+	}
+}
+
+const recordingHeader = "intcode replay v1"
+
+// WriteRecordings writes the recordings of several computers, such as the
+// ones connected in a pipeline, into a replay file. Each line holds the index
+// of a computer, a step, the kind of record and its value.
+func WriteRecordings(w io.Writer, recs []*Recording) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "%s %d\n", recordingHeader, len(recs))
+	for node, rec := range recs {
+		for _, r := range rec.Records {
+			fmt.Fprintf(bw, "%d %d %s %d\n", node, r.Step, recordKinds[r.Kind], r.Value)
+		}
+	}
+	return bw.Flush()
+}
+
+var recordKinds = map[EventKind]string{Input: "in", Output: "out", Halted: "halt"}
+
+// ReadRecordings reads a replay file written by WriteRecordings.
+func ReadRecordings(r io.Reader) ([]*Recording, error) {
+	s := bufio.NewScanner(r)
+	if !s.Scan() {
+		return nil, fmt.Errorf("missing replay header: %v", s.Err())
+	}
+	var n int
+	if _, err := fmt.Sscanf(s.Text(), recordingHeader+" %d", &n); err != nil || n < 0 {
+		return nil, fmt.Errorf("bad replay header %q", s.Text())
+	}
+
+	recs := make([]*Recording, n)
+	for i := range recs {
+		recs[i] = new(Recording)
+	}
+	for line := 2; s.Scan(); line++ {
+		var (
+			node int
+			r    IORecord
+			kind string
+		)
+		if _, err := fmt.Sscanf(s.Text(), "%d %d %s %d", &node, &r.Step, &kind, &r.Value); err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		if node < 0 || node >= n {
+			return nil, fmt.Errorf("line %d: computer %d out of %d", line, node, n)
+		}
+		found := false
+		for k, name := range recordKinds {
+			if name == kind {
+				r.Kind, found = k, true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("line %d: unknown record kind %q", line, kind)
+		}
+		recs[node].Records = append(recs[node].Records, r)
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+
+	for node, rec := range recs {
+		if !sort.SliceIsSorted(rec.Records, func(i, j int) bool { return rec.Records[i].Step < rec.Records[j].Step }) {
+			return nil, fmt.Errorf("records of computer %d are not in order", node)
+		}
+	}
+	return recs, nil
+}
@@ -0,0 +1,131 @@
+package intcode
+
+import "errors"
+
+// ErrDeadlock is returned by Scheduler.Run when every computer that has not
+// halted is blocked on its input or output.
+var ErrDeadlock = errors.New("all computers are blocked")
+
+// A Scheduler runs several computers cooperatively on a single goroutine.
+// Each computer runs until it halts or blocks on its input or output, and then
+// the next one gets its turn, always in the order they were added. This makes
+// the interleaving of their instructions, and any trace of it, reproducible.
+//
+// Since no other goroutine is there to receive or send values, the channels
+// connecting the computers must be buffered.
+type Scheduler struct {
+	computers []*Computer
+
+	// Output, if not nil, is called with the index of the computer and the
+	// value every time one of the computers produces an output.
+	Output func(node, value int)
+
+	// Step, if not nil, is called with the index of the computer and the
+	// event every time one of the computers runs an instruction.
+	Step func(node int, ev Event)
+
+	// taints holds, for each channel read by a computer tracking taint, the
+	// taint of the values sent to it by the other computers.
+	taints map[chan int][]Taint
+}
+
+// NewScheduler returns a scheduler for the given computers, which must not be
+// run by any other means.
+func NewScheduler(computers ...*Computer) *Scheduler {
+	for _, c := range computers {
+		c.nonBlocking = true
+	}
+	return &Scheduler{computers: computers}
+}
+
+// Run runs all of the computers until they halt. If one of them fails, the
+// rest are stopped too, and the stdout of every computer is closed before Run
+// returns a *NodeError.
+func (s *Scheduler) Run() error {
+	s.connectTaint()
+	for {
+		running, progress := false, false
+		for i, c := range s.computers {
+			steps, err := s.runUntilBlocked(i, c)
+			if err != nil {
+				s.stop()
+				return &NodeError{Node: i, PC: c.nextInst, Err: err}
+			}
+			progress = progress || steps > 0
+			running = running || !c.done
+		}
+		if !running {
+			return nil
+		}
+		if !progress {
+			s.stop()
+			return ErrDeadlock
+		}
+	}
+}
+
+// stop closes the stdout of every computer that has not halted yet.
+func (s *Scheduler) stop() {
+	for _, c := range s.computers {
+		if !c.done {
+			c.closeStdout()
+		}
+	}
+}
+
+// runUntilBlocked runs the i-th computer until it halts or blocks, and returns
+// how many instructions it executed.
+func (s *Scheduler) runUntilBlocked(i int, c *Computer) (int, error) {
+	steps := 0
+	for !c.done {
+		ev, err := c.Step()
+		if err == errWouldBlock {
+			return steps, nil
+		}
+		if err != nil {
+			return steps, err
+		}
+		if ev.Kind == NeedsInput {
+			return steps, c.inputExhausted()
+		}
+		steps++
+
+		if s.Step != nil {
+			s.Step(i, ev)
+		}
+		if ev.Kind == Output {
+			if q, ok := s.taints[c.stdout]; ok {
+				s.taints[c.stdout] = append(q, ev.Taint)
+			}
+			if s.Output != nil {
+				s.Output(i, ev.Value)
+			}
+		}
+	}
+	return steps, nil
+}
+
+// connectTaint makes the computers tracking taint take the taint of the
+// values they receive from the other computers from s.taints.
+func (s *Scheduler) connectTaint() {
+	for _, c := range s.computers {
+		if c.taint == nil || c.stdin == nil {
+			continue
+		}
+		if s.taints == nil {
+			s.taints = make(map[chan int][]Taint)
+		}
+		ch := c.stdin
+		s.taints[ch] = nil
+		c.taint.stdinTaint = func() (Taint, bool) {
+			// The values sent from outside the scheduler, which are not in
+			// the queue, were all in the channel before it started.
+			q := s.taints[ch]
+			if len(q) <= len(ch) {
+				return nil, false
+			}
+			s.taints[ch] = q[1:]
+			return q[0], true
+		}
+	}
+}
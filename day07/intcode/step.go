@@ -0,0 +1,155 @@
+package intcode
+
+// EventKind identifies what happened during a call to Step.
+type EventKind int
+
+const (
+	// Executed means an instruction other than input, output or halt ran.
+	Executed EventKind = iota
+	// Input means an input instruction consumed a value.
+	Input
+	// Output means an output instruction produced Event.Value.
+	Output
+	// NeedsInput means the next instruction is an input, but no value was
+	// given with Provide and there is no stdin to wait on. Nothing was run.
+	NeedsInput
+	// Halted means the program has halted.
+	Halted
+)
+
+func (k EventKind) String() string {
+	switch k {
+	case Executed:
+		return "executed"
+	case Input:
+		return "input"
+	case Output:
+		return "output"
+	case NeedsInput:
+		return "needs input"
+	case Halted:
+		return "halted"
+	}
+	return "unknown"
+}
+
+// Event describes the effect of a call to Step.
+type Event struct {
+	Kind  EventKind
+	PC    int // address of the instruction that was, or could not be, run.
+	Value int // the value read or written by input and output instructions.
+
+	// Taint is the set of inputs Value depends on, for input and output
+	// events of a computer tracking taint.
+	Taint Taint
+
+	// Watch lists the accesses to memory watched with Watch.
+	Watch []WatchHit
+	// Breakpoint is the identifier of the breakpoint that stopped Continue
+	// or ReverseContinue, if any.
+	Breakpoint int
+}
+
+// Step runs the next instruction of the program.
+//
+// A computer created with nil channels can be driven entirely through Step:
+// inputs are given with Provide, outputs are reported as Output events, and
+// Step returns a NeedsInput event when the program waits for more input.
+func (c *Computer) Step() (Event, error) {
+	if c.done {
+		return Event{Kind: Halted, PC: c.nextInst}, nil
+	}
+	c.hits = c.hits[:0]
+	// Steps replayed to go back in the history already ran, and were
+	// already covered, recorded and traced then.
+	replaying := c.hist.isReplaying()
+	if c.hist != nil {
+		c.hist.begin(c)
+	}
+	if c.tr != nil && !replaying {
+		c.tr.begin(c)
+	}
+	ev, err := c.stepEvent()
+	if err == nil && ev.Kind != NeedsInput {
+		if len(c.hits) > 0 {
+			ev.Watch = append([]WatchHit(nil), c.hits...)
+		}
+		if c.cov != nil && !replaying && ev.PC < len(c.cov.Executed) {
+			c.cov.Executed[ev.PC]++
+		}
+		if c.rec != nil && !replaying {
+			c.rec.record(ev)
+		}
+	}
+	if c.hist != nil {
+		c.hist.end(c, ev, err)
+	}
+	if c.tr != nil && !replaying && err == nil && ev.Kind != NeedsInput {
+		if err := c.tr.end(ev); err != nil {
+			return ev, err
+		}
+	}
+	return ev, err
+}
+
+func (c *Computer) stepEvent() (Event, error) {
+	pc, rb := c.nextInst, c.relBase
+	ins, err := c.step()
+	switch {
+	case err == errNeedsInput:
+		return Event{Kind: NeedsInput, PC: pc}, nil
+	case err == ErrInputExhausted:
+		if err := c.inputExhausted(); err != nil {
+			return Event{PC: pc}, err
+		}
+		return Event{Kind: Halted, PC: pc}, nil
+	case err != nil:
+		return Event{PC: pc}, err
+	}
+
+	var taint Taint
+	if c.taint != nil {
+		c.taint.propagate(ins, pc, rb)
+		taint = c.taint.current
+	}
+	switch ins := ins.(type) {
+	case *inputInstruction:
+		return Event{Kind: Input, PC: pc, Value: ins.value, Taint: taint}, nil
+	case *outputInstruction:
+		return Event{Kind: Output, PC: pc, Value: ins.value, Taint: taint}, nil
+	case *haltInstruction:
+		c.closeStdout()
+		return Event{Kind: Halted, PC: pc}, nil
+	}
+	return Event{Kind: Executed, PC: pc}, nil
+}
+
+// RunUntil steps through the program until cond returns true for an event,
+// and returns that event. It also returns when the program halts or needs an
+// input, since it can't make progress after that.
+func (c *Computer) RunUntil(cond func(Event) bool) (Event, error) {
+	for {
+		ev, err := c.Step()
+		if err != nil || cond(ev) || ev.Kind == Halted || ev.Kind == NeedsInput {
+			return ev, err
+		}
+	}
+}
+
+// Provide queues values to be consumed by input instructions before any
+// value coming from stdin.
+func (c *Computer) Provide(values ...int) { c.pending = append(c.pending, values...) }
+
+// PC returns the address of the next instruction to run.
+func (c *Computer) PC() int { return c.nextInst }
+
+// RelativeBase returns the base used by relative mode parameters.
+func (c *Computer) RelativeBase() int { return c.relBase }
+
+// Halted reports whether the program has halted.
+func (c *Computer) Halted() bool { return c.done }
+
+// Memory returns the memory of the computer. The slice is shared with the
+// computer until its memory grows, so it reflects any later change and can be
+// used to patch the program before or during its execution.
+func (c *Computer) Memory() []int { return c.cells }
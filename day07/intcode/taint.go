@@ -0,0 +1,173 @@
+package intcode
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// A Taint is the set of labels of the inputs a value depends on, in order.
+// Taints are never modified once created, so they can be shared freely.
+type Taint []string
+
+// Union returns the labels in either t or u.
+func (t Taint) Union(u Taint) Taint {
+	switch {
+	case len(u) == 0:
+		return t
+	case len(t) == 0:
+		return u
+	}
+	var res Taint
+	i, j := 0, 0
+	for i < len(t) && j < len(u) {
+		switch {
+		case t[i] < u[j]:
+			res = append(res, t[i])
+			i++
+		case t[i] > u[j]:
+			res = append(res, u[j])
+			j++
+		default:
+			res = append(res, t[i])
+			i, j = i+1, j+1
+		}
+	}
+	res = append(append(res, t[i:]...), u[j:]...)
+	if len(res) == len(t) {
+		return t
+	}
+	return res
+}
+
+// Has reports whether label is one of the labels of t.
+func (t Taint) Has(label string) bool {
+	i := sort.SearchStrings(t, label)
+	return i < len(t) && t[i] == label
+}
+
+func (t Taint) String() string { return "{" + strings.Join(t, ", ") + "}" }
+
+// TaintFlow selects how taint is propagated.
+type TaintFlow int
+
+const (
+	// DataFlow propagates taint from the operands of additions,
+	// multiplications and comparisons to their results, and through memory.
+	// A value read through an address computed from the inputs, including
+	// one relative to a base moved by them, also depends on those inputs.
+	DataFlow TaintFlow = iota
+	// ControlFlow also makes every value computed after a conditional jump
+	// depend on the inputs that decided whether it jumped or where to. This
+	// is conservative, since the taint of the control flow is never cleared.
+	ControlFlow
+)
+
+// TrackTaint makes the computer track which of its inputs every value in its
+// memory depends on, and report them as the Taint of its input and output
+// events. The n-th input consumed, counting from zero, is labeled with
+// label(n), or "input n" if label is nil.
+//
+// When computers connected by channels are run by a Scheduler, the taint of
+// the values sent by one computer is carried to the one receiving them, and
+// only the values sent from outside the scheduler are labeled. Like Record,
+// only steps run forward are tracked.
+func (c *Computer) TrackTaint(flow TaintFlow, label func(n int) Taint) {
+	if label == nil {
+		label = func(n int) Taint { return Taint{fmt.Sprintf("input %d", n)} }
+	}
+	c.taint = &taintTracker{flow: flow, label: label, cells: make([]Taint, len(c.cells))}
+}
+
+// TaintOf returns the taint of the value in memory at addr, if the computer is
+// tracking taint.
+func (c *Computer) TaintOf(addr int) Taint {
+	if c.taint == nil {
+		return nil
+	}
+	return c.taint.at(addr)
+}
+
+type taintTracker struct {
+	flow    TaintFlow
+	label   func(n int) Taint
+	inputs  int
+	cells   []Taint
+	relBase Taint
+
+	// control is the taint of the decisions taken by conditional jumps so
+	// far, with the ControlFlow propagation.
+	control Taint
+
+	// fromStdin is set when the last input came from stdin, where the
+	// scheduler running the computer may know its taint through stdinTaint.
+	fromStdin  bool
+	stdinTaint func() (Taint, bool)
+
+	// current is the taint of the value of the last input or output.
+	current Taint
+}
+
+func (t *taintTracker) at(addr int) Taint {
+	if addr < 0 || addr >= len(t.cells) {
+		return nil
+	}
+	return t.cells[addr]
+}
+
+// propagate updates the taint of memory after the instruction at pc ran, with
+// the given relative base.
+func (t *taintTracker) propagate(ins instruction, pc, rb int) {
+	switch ins := ins.(type) {
+	case binaryOp:
+		op := ins.operands()
+		taint := t.param(op.src1, pc+1, rb).Union(t.param(op.src2, pc+2, rb))
+		t.set(op.dest, rb, taint.Union(t.control))
+	case *inputInstruction:
+		var (
+			taint Taint
+			ok    bool
+		)
+		if t.fromStdin && t.stdinTaint != nil {
+			taint, ok = t.stdinTaint()
+		}
+		if !ok {
+			taint = t.label(t.inputs)
+		}
+		t.current, t.fromStdin = taint, false
+		t.inputs++
+		t.set(ins.arg, rb, t.current)
+	case *outputInstruction:
+		t.current = t.param(ins.arg, pc+1, rb).Union(t.control)
+	case *relBaseInstruction:
+		t.relBase = t.relBase.Union(t.param(ins.arg, pc+1, rb))
+	case *condJumpInstruction:
+		if t.flow == ControlFlow {
+			decision := t.param(ins.cond, pc+1, rb).Union(t.param(ins.target, pc+2, rb))
+			t.control = t.control.Union(decision)
+		}
+	}
+}
+
+// param returns the taint of the value of the parameter stored at cell.
+func (t *taintTracker) param(p parameter, cell, rb int) Taint {
+	taint := t.at(cell)
+	if p.mode == immediateMode {
+		return taint
+	}
+	if p.mode == relativeMode {
+		taint = taint.Union(t.relBase)
+	}
+	return t.at(p.addr(rb)).Union(taint)
+}
+
+func (t *taintTracker) set(p parameter, rb int, taint Taint) {
+	addr := p.addr(rb)
+	if addr < 0 {
+		return
+	}
+	for addr >= len(t.cells) {
+		t.cells = append(t.cells, nil)
+	}
+	t.cells[addr] = taint
+}
@@ -0,0 +1,170 @@
+package intcode
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// A trace is a JSON Lines stream describing the execution of a computer. Its
+// first line is a TraceHeader holding the memory before the first traced step,
+// and each following line is a TraceStep.
+
+// TraceHeader is the first line of a trace.
+type TraceHeader struct {
+	Memory []int `json:"memory"`
+}
+
+// TraceStep describes one instruction run by a traced computer.
+type TraceStep struct {
+	Step    int          `json:"step"`
+	PC      int          `json:"pc"`
+	RelBase int          `json:"rb"` // relative base before the instruction ran.
+	Inst    string       `json:"inst"`
+	Kind    EventKind    `json:"kind"`
+	Value   int          `json:"value,omitempty"`
+	Writes  []TraceWrite `json:"writes,omitempty"`
+}
+
+// TraceWrite is a value written to memory by a traced instruction.
+type TraceWrite struct {
+	Addr  int `json:"addr"`
+	Value int `json:"value"`
+}
+
+// SameFlow reports whether s and t ran the same instruction at the same
+// address, regardless of the values they read and wrote.
+func (s TraceStep) SameFlow(t TraceStep) bool {
+	return s.PC == t.PC && s.Inst == t.Inst && s.Kind == t.Kind
+}
+
+// Equal reports whether s and t ran the same instruction with the same
+// effects. Their step numbers are not compared.
+func (s TraceStep) Equal(t TraceStep) bool {
+	if !s.SameFlow(t) || s.RelBase != t.RelBase || s.Value != t.Value || len(s.Writes) != len(t.Writes) {
+		return false
+	}
+	for i := range s.Writes {
+		if s.Writes[i] != t.Writes[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func (s TraceStep) String() string {
+	text := fmt.Sprintf("step %d: pc %d rb %d %s", s.Step, s.PC, s.RelBase, s.Inst)
+	if s.Kind == Input || s.Kind == Output {
+		text += fmt.Sprintf(" (%v %d)", s.Kind, s.Value)
+	}
+	for _, w := range s.Writes {
+		text += fmt.Sprintf(" [%d]=%d", w.Addr, w.Value)
+	}
+	return text
+}
+
+// MarshalText encodes the kind as its name.
+func (k EventKind) MarshalText() ([]byte, error) { return []byte(k.String()), nil }
+
+// UnmarshalText decodes a kind from its name.
+func (k *EventKind) UnmarshalText(text []byte) error {
+	for kind := Executed; kind <= Halted; kind++ {
+		if kind.String() == string(text) {
+			*k = kind
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown event kind %q", text)
+}
+
+// Trace makes the computer write a trace of every instruction it runs from
+// now on to w, starting with a header holding its current memory. If writing
+// the trace fails, the step being traced returns the error, although the
+// instruction has already run. Like Record, only steps run forward are
+// traced.
+func (c *Computer) Trace(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(TraceHeader{Memory: c.cells}); err != nil {
+		return err
+	}
+	c.TraceFunc(func(s TraceStep) error {
+		if err := enc.Encode(s); err != nil {
+			return fmt.Errorf("could not write trace: %v", err)
+		}
+		return nil
+	})
+	return nil
+}
+
+// TraceFunc makes the computer call f with every instruction it runs from now
+// on, described as in a trace, right after running it. If f fails, the step
+// being traced returns its error. A computer has a single trace, so TraceFunc
+// replaces the one set by Trace or an earlier call, and a nil f stops
+// tracing.
+func (c *Computer) TraceFunc(f func(TraceStep) error) {
+	if f == nil {
+		c.tr = nil
+		return
+	}
+	c.tr = &tracer{emit: f}
+}
+
+type tracer struct {
+	emit    func(TraceStep) error
+	step    int
+	current TraceStep
+}
+
+func (t *tracer) begin(c *Computer) {
+	inst, _, err := Disassemble(c.cells, c.nextInst)
+	if err != nil {
+		inst = "?"
+	}
+	t.current = TraceStep{Step: t.step, PC: c.nextInst, RelBase: c.relBase, Inst: inst}
+}
+
+func (t *tracer) wrote(addr, val int) {
+	t.current.Writes = append(t.current.Writes, TraceWrite{addr, val})
+}
+
+func (t *tracer) end(ev Event) error {
+	t.current.Kind = ev.Kind
+	if ev.Kind == Input || ev.Kind == Output {
+		t.current.Value = ev.Value
+	}
+	t.step++
+	return t.emit(t.current)
+}
+
+// Trace is a trace read back with ReadTrace.
+type Trace struct {
+	Memory []int
+	Steps  []TraceStep
+}
+
+// ReadTrace reads a whole trace written by a computer.
+func ReadTrace(r io.Reader) (*Trace, error) {
+	s := bufio.NewScanner(r)
+	s.Buffer(nil, 1<<30)
+	if !s.Scan() {
+		if err := s.Err(); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("missing trace header")
+	}
+	var header TraceHeader
+	if err := json.Unmarshal(s.Bytes(), &header); err != nil {
+		return nil, fmt.Errorf("line 1: %v", err)
+	}
+
+	t := &Trace{Memory: header.Memory}
+	for line := 2; s.Scan(); line++ {
+		var step TraceStep
+		if err := json.Unmarshal(s.Bytes(), &step); err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		t.Steps = append(t.Steps, step)
+	}
+	return t, s.Err()
+}
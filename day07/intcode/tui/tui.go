@@ -0,0 +1,469 @@
+// Package tui shows an Intcode computer running in a full-screen terminal
+// view, drawn with plain ANSI escape sequences.
+//
+// The view holds a disassembly of the program around the PC, a strip showing
+// which parts of memory are being executed and written, the log of inputs
+// and outputs, and the number of instructions run per second. It follows the
+// computer through its tracing hook, so it works with any computer that isn't
+// otherwise traced.
+//
+// Keys:
+//
+//	space, p   pause or resume
+//	s, n       run a single instruction, pausing first
+//	+, -       run faster or slower
+//	i          type an input, given to the program with enter
+//	q, ctrl-c  quit
+package tui
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/campoy/advent-of-code-2019/day07/intcode"
+)
+
+// Options configures Run.
+type Options struct {
+	// Width and Height are the size of the terminal, 80x24 if zero.
+	Width, Height int
+	// Speed is the initial number of instructions run per second, 100 if
+	// zero and as many as possible if negative.
+	Speed int
+	// Paused starts the view paused.
+	Paused bool
+	// Symbols are used to disassemble the program.
+	Symbols intcode.Symbols
+}
+
+// speeds are the speeds selected with + and -, where 0 runs as many
+// instructions as possible.
+var speeds = []int{1, 10, 100, 1000, 10000, 100000, 1000000, 0}
+
+const (
+	fps = 30
+
+	// logSize is the number of inputs and outputs kept in the log.
+	logSize = 100
+	// recentSize is the number of recent PCs used to show some of the
+	// instructions run before the current one.
+	recentSize = 4
+
+	ansiReverse = "\x1b[7m"
+	ansiBold    = "\x1b[1m"
+	ansiRed     = "\x1b[31m"
+	ansiReset   = "\x1b[0m"
+	ansiClearLn = "\x1b[K"
+)
+
+// Run shows the computer running in the terminal, reading keys from keys and
+// drawing on w, which should be a terminal in raw mode. It returns when q is
+// pressed or keys ends, with the error the program failed with if any.
+func Run(c *intcode.Computer, keys io.Reader, w io.Writer, opts Options) error {
+	v := newView(c, opts)
+	c.TraceFunc(v.traced)
+	defer c.TraceFunc(nil)
+
+	keyc := make(chan byte)
+	go func() {
+		r := bufio.NewReader(keys)
+		for {
+			k, err := r.ReadByte()
+			if err != nil {
+				close(keyc)
+				return
+			}
+			keyc <- k
+		}
+	}()
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprint(bw, "\x1b[?1049h\x1b[?25l")
+	defer func() {
+		fmt.Fprint(bw, "\x1b[?25h\x1b[?1049l")
+		bw.Flush()
+	}()
+
+	ticker := time.NewTicker(time.Second / fps)
+	defer ticker.Stop()
+	v.render(bw)
+	for {
+		select {
+		case k, ok := <-keyc:
+			if !ok || !v.key(k) {
+				return v.err
+			}
+		case now := <-ticker.C:
+			v.tick(now)
+		}
+		v.render(bw)
+		if err := bw.Flush(); err != nil {
+			return err
+		}
+	}
+}
+
+// A view holds the state of the terminal view of a computer.
+type view struct {
+	c    *intcode.Computer
+	opts Options
+
+	speed  int // index in speeds.
+	paused bool
+	err    error
+
+	// waiting is set when the program needs an input and none was given.
+	waiting bool
+	// typing is set while an input is being typed into prompt.
+	typing bool
+	prompt string
+
+	// heat measures how recently each cell was executed or written.
+	heat []float64
+	log  []string
+	// recent holds the PCs of the last instructions run, oldest first.
+	recent []int
+	// listFrom is the address the disassembly starts from.
+	listFrom int
+
+	steps int
+	// budget carries the fraction of an instruction due in a frame at slow
+	// speeds over to the next one.
+	budget float64
+
+	// rate is the number of instructions per second, measured since
+	// rateSince when rateSteps had been run.
+	rate      float64
+	rateSince time.Time
+	rateSteps int
+}
+
+func newView(c *intcode.Computer, opts Options) *view {
+	if opts.Width <= 0 {
+		opts.Width = 80
+	}
+	if opts.Height <= 0 {
+		opts.Height = 24
+	}
+	v := &view{
+		c:         c,
+		opts:      opts,
+		paused:    opts.Paused,
+		heat:      make([]float64, len(c.Memory())),
+		listFrom:  c.PC(),
+		rateSince: time.Now(),
+	}
+	v.speed = len(speeds) - 1
+	if opts.Speed >= 0 {
+		want := opts.Speed
+		if want == 0 {
+			want = 100
+		}
+		// Start at the fastest speed not above the one wanted.
+		for v.speed = 0; v.speed < len(speeds)-2 && speeds[v.speed+1] <= want; v.speed++ {
+		}
+	}
+	return v
+}
+
+// traced is called by the computer after every instruction it runs.
+func (v *view) traced(s intcode.TraceStep) error {
+	v.steps++
+	v.warm(s.PC)
+	for _, w := range s.Writes {
+		v.warm(w.Addr)
+	}
+	switch s.Kind {
+	case intcode.Input:
+		v.logf("%8d  in  %d", s.Step, s.Value)
+	case intcode.Output:
+		v.logf("%8d  out %d", s.Step, s.Value)
+	}
+	v.recent = append(v.recent, s.PC)
+	if len(v.recent) > recentSize {
+		v.recent = v.recent[1:]
+	}
+	return nil
+}
+
+// warm heats the cell at addr, growing the heat map along with the memory
+// of the program.
+func (v *view) warm(addr int) {
+	if addr < 0 {
+		return
+	}
+	if addr >= len(v.heat) {
+		v.heat = append(v.heat, make([]float64, addr+1-len(v.heat))...)
+	}
+	v.heat[addr]++
+}
+
+func (v *view) logf(format string, args ...interface{}) {
+	v.log = append(v.log, fmt.Sprintf(format, args...))
+	if len(v.log) > logSize {
+		v.log = v.log[1:]
+	}
+}
+
+// key handles a key press, and reports whether to keep running.
+func (v *view) key(k byte) bool {
+	if v.typing {
+		switch {
+		case k == '\r' || k == '\n':
+			v.typing = false
+			n, err := strconv.Atoi(v.prompt)
+			if err != nil {
+				v.logf("bad input %q", v.prompt)
+				return true
+			}
+			v.c.Provide(n)
+			v.waiting = false
+		case k == 0x1b:
+			v.typing = false
+		case k == 0x7f || k == '\b':
+			if len(v.prompt) > 0 {
+				v.prompt = v.prompt[:len(v.prompt)-1]
+			}
+		case k >= '0' && k <= '9' || k == '-':
+			v.prompt += string(k)
+		}
+		return true
+	}
+
+	switch k {
+	case 'q', 0x03:
+		return false
+	case ' ', 'p':
+		v.paused = !v.paused
+		v.budget = 0
+	case 's', 'n':
+		v.paused = true
+		v.run(1)
+	case '+', '=':
+		if v.speed < len(speeds)-1 {
+			v.speed++
+		}
+	case '-', '_':
+		if v.speed > 0 {
+			v.speed--
+		}
+	case 'i':
+		v.typing, v.prompt = true, ""
+	}
+	return true
+}
+
+// tick runs the instructions due in a frame, and updates the rate.
+func (v *view) tick(now time.Time) {
+	for i := range v.heat {
+		v.heat[i] *= 0.9
+	}
+	if !v.paused {
+		if speed := speeds[v.speed]; speed > 0 {
+			v.budget += float64(speed) / fps
+			n := int(v.budget)
+			v.budget -= float64(n)
+			v.run(n)
+		} else {
+			// Run as many instructions as fit in half a frame.
+			deadline := now.Add(time.Second / fps / 2)
+			for v.run(1000) && time.Now().Before(deadline) {
+			}
+		}
+	}
+	if elapsed := now.Sub(v.rateSince); elapsed >= time.Second/2 {
+		v.rate = float64(v.steps-v.rateSteps) / elapsed.Seconds()
+		v.rateSince, v.rateSteps = now, v.steps
+	}
+}
+
+// run runs up to n instructions, and reports whether the program can go on.
+func (v *view) run(n int) bool {
+	for i := 0; i < n; i++ {
+		if v.err != nil || v.c.Halted() {
+			return false
+		}
+		ev, err := v.c.Step()
+		if err != nil {
+			v.err = fmt.Errorf("at %d: %v", ev.PC, err)
+			v.logf("error: %v", v.err)
+			return false
+		}
+		v.waiting = ev.Kind == intcode.NeedsInput
+		if v.waiting {
+			return false
+		}
+	}
+	return true
+}
+
+// render draws the whole view on w.
+func (v *view) render(w io.Writer) {
+	width, height := v.opts.Width, v.opts.Height
+	fmt.Fprint(w, "\x1b[H")
+	line := func(s string) { fmt.Fprint(w, s, ansiClearLn, "\r\n") }
+
+	style := ansiBold
+	if v.err != nil {
+		style += ansiRed
+	}
+	line(style + clip(v.status(), width) + ansiReset)
+	line(v.strip(width))
+
+	rows := height - 4
+	if rows < 1 {
+		rows = 1
+	}
+	listWidth := width * 3 / 5
+	listing := v.listing(rows, listWidth)
+	log := v.log
+	if len(log) > rows {
+		log = log[len(log)-rows:]
+	}
+	for i := 0; i < rows; i++ {
+		var left, right string
+		if i < len(listing) {
+			left = listing[i]
+		}
+		if i < len(log) {
+			right = clip(log[i], width-listWidth-1)
+		}
+		line(left + " " + right)
+	}
+
+	line("")
+	if v.typing {
+		fmt.Fprint(w, "input: ", v.prompt, ansiClearLn)
+	} else {
+		fmt.Fprint(w, clip("space pause  s step  +/- speed  i input  q quit", width), ansiClearLn)
+	}
+	fmt.Fprint(w, "\x1b[J")
+}
+
+func (v *view) status() string {
+	state := "running"
+	switch {
+	case v.err != nil:
+		state = "failed"
+	case v.c.Halted():
+		state = "halted"
+	case v.waiting:
+		state = "waiting for input"
+	case v.paused:
+		state = "paused"
+	}
+	speed := "max"
+	if s := speeds[v.speed]; s > 0 {
+		speed = fmt.Sprintf("%d/s", s)
+	}
+	return fmt.Sprintf("pc %d  rb %d  %s  speed %s  %d steps  %.0f ins/s",
+		v.c.PC(), v.c.RelativeBase(), state, speed, v.steps, v.rate)
+}
+
+// heatColors go from the coldest to the hottest cells.
+var heatColors = []string{"\x1b[34m", "\x1b[36m", "\x1b[32m", "\x1b[33m", "\x1b[31m"}
+
+// strip draws memory as a single row, where each character shows the hottest
+// of the cells it covers, and the one holding the PC is highlighted.
+func (v *view) strip(width int) string {
+	if len(v.heat) == 0 || width < 1 {
+		return ""
+	}
+	cols := width
+	if cols > len(v.heat) {
+		cols = len(v.heat)
+	}
+	per := (len(v.heat) + cols - 1) / cols
+	var b strings.Builder
+	for from := 0; from < len(v.heat); from += per {
+		to := from + per
+		if to > len(v.heat) {
+			to = len(v.heat)
+		}
+		hottest := 0.0
+		for _, h := range v.heat[from:to] {
+			hottest = math.Max(hottest, h)
+		}
+		if pc := v.c.PC(); pc >= from && pc < to && !v.c.Halted() {
+			b.WriteString(ansiReverse)
+		}
+		if hottest < 0.05 {
+			b.WriteString("·" + ansiReset)
+			continue
+		}
+		level := int(math.Log2(1 + hottest))
+		if level >= len(heatColors) {
+			level = len(heatColors) - 1
+		}
+		b.WriteString(heatColors[level] + "█" + ansiReset)
+	}
+	b.WriteString(ansiReset)
+	return b.String()
+}
+
+// listing disassembles rows instructions, including the one under the PC
+// and if possible some of the ones run just before it.
+func (v *view) listing(rows, width int) []string {
+	mem := v.c.Memory()
+	pc := v.c.PC()
+	if !v.lists(v.listFrom, pc, rows-1) {
+		v.listFrom = pc
+		for _, from := range v.recent {
+			if from <= pc && v.lists(from, pc, rows/2) {
+				v.listFrom = from
+				break
+			}
+		}
+	}
+
+	var lines []string
+	for addr := v.listFrom; len(lines) < rows && addr < len(mem); {
+		inst, next, err := v.opts.Symbols.Disassemble(mem, addr)
+		if err != nil {
+			inst, next = fmt.Sprintf("%d", mem[addr]), addr+1
+		}
+		text := fmt.Sprintf("%6d  %s", addr, inst)
+		if name := v.opts.Symbols[addr]; name != "" {
+			text = fmt.Sprintf("%6d  %s: %s", addr, name, inst)
+		}
+		text = fmt.Sprintf("%-*s", width, clip(text, width))
+		if addr == pc && !v.c.Halted() {
+			text = ansiReverse + text + ansiReset
+		}
+		lines = append(lines, text)
+		addr = next
+	}
+	return lines
+}
+
+// lists reports whether a disassembly from from reaches the instruction at
+// pc within rows instructions.
+func (v *view) lists(from, pc, rows int) bool {
+	mem := v.c.Memory()
+	for addr, i := from, 0; i < rows && addr <= pc; i++ {
+		if addr == pc {
+			return true
+		}
+		_, next, err := v.opts.Symbols.Disassemble(mem, addr)
+		if err != nil {
+			return false
+		}
+		addr = next
+	}
+	return false
+}
+
+func clip(s string, width int) string {
+	if width < 0 {
+		return ""
+	}
+	if r := []rune(s); len(r) > width {
+		return string(r[:width])
+	}
+	return s
+}
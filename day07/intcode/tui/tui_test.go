@@ -0,0 +1,62 @@
+package tui
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/campoy/advent-of-code-2019/day07/intcode"
+)
+
+func TestRun(t *testing.T) {
+	// echo reads a value, outputs it twice and halts.
+	c := intcode.NewComputer([]int{3, 0, 4, 0, 4, 0, 99}, nil, nil)
+
+	// Step until the program waits for input, type it, and step to the end.
+	var out strings.Builder
+	keys := strings.NewReader("si42\rssssq")
+	if err := Run(c, keys, &out, Options{Paused: true}); err != nil {
+		t.Fatal(err)
+	}
+	if !c.Halted() {
+		t.Fatalf("expected the program to halt")
+	}
+
+	frames := strings.Split(out.String(), "\x1b[H")
+	last := frames[len(frames)-1]
+	for _, want := range []string{"halted", "4 steps", "       0  in  42", "       1  out 42", "       2  out 42"} {
+		if !strings.Contains(last, want) {
+			t.Errorf("expected the last frame to contain %q; got:\n%s", want, last)
+		}
+	}
+}
+
+func TestRunGrowsMemory(t *testing.T) {
+	// Writes past the end of the program, which grows its memory.
+	c := intcode.NewComputer([]int{1101, 1, 2, 100, 99}, nil, nil)
+	var out strings.Builder
+	if err := Run(c, strings.NewReader("ssq"), &out, Options{Paused: true}); err != nil {
+		t.Fatal(err)
+	}
+	if !c.Halted() || len(c.Memory()) != 101 || c.Memory()[100] != 3 {
+		t.Fatalf("expected the program to halt after writing 3 at 100; got %d cells", len(c.Memory()))
+	}
+}
+
+func TestListing(t *testing.T) {
+	// A loop counting down from 3, followed by data.
+	c := intcode.NewComputer([]int{1001, 9, -1, 9, 1005, 9, 0, 99, 0, 3}, nil, nil)
+	v := newView(c, Options{Symbols: intcode.Symbols{9: "n"}})
+	c.TraceFunc(v.traced)
+	v.run(4)
+
+	// The PC is back at the start of the loop.
+	got := v.listing(3, 30)
+	want := []string{
+		ansiReverse + "     0  ADD @n = @n + -1      " + ansiReset,
+		"     4  JumpIf(true) @n 0     ",
+		"     7  HALT                  ",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("expected listing:\n%q\ngot:\n%q", want, got)
+	}
+}
@@ -0,0 +1,206 @@
+package intcode
+
+import (
+	"fmt"
+	"sort"
+)
+
+// WarningKind classifies the problems found by Verify.
+type WarningKind int
+
+const (
+	// InvalidInstruction is an instruction that can't be decoded, or the
+	// end of the program reached without halting.
+	InvalidInstruction WarningKind = iota
+	// ImmediateWrite is an instruction writing through an immediate mode
+	// parameter, which makes it fail.
+	ImmediateWrite
+	// JumpOutside is a jump to a constant address outside of the program.
+	JumpOutside
+	// SelfModifying is an instruction writing into the code of the program.
+	SelfModifying
+)
+
+func (k WarningKind) String() string {
+	switch k {
+	case InvalidInstruction:
+		return "invalid instruction"
+	case ImmediateWrite:
+		return "immediate write"
+	case JumpOutside:
+		return "jump outside"
+	case SelfModifying:
+		return "self-modifying"
+	}
+	return "unknown"
+}
+
+// A Warning is a problem found by Verify in the instruction at Addr.
+type Warning struct {
+	Addr int
+	Kind WarningKind
+	Msg  string
+}
+
+func (w Warning) String() string { return fmt.Sprintf("%d: %v: %s", w.Addr, w.Kind, w.Msg) }
+
+// Verify looks for problems in the instructions of program reachable from its
+// start, without running it, and returns them ordered by address.
+//
+// Instructions are followed through the jumps whose target is an immediate
+// value, taking or skipping the ones whose condition is immediate too. The
+// code reached through the other jumps, whose target is only known at run
+// time, isn't verified. Likewise, only the writes to the addresses given by
+// position mode parameters are checked for modifying the code, since the
+// ones relative to the relative base can't be resolved.
+func Verify(program []int) []Warning {
+	return analyze(program).warnings
+}
+
+// An analysis holds what is known about the code of a program without running
+// it.
+type analysis struct {
+	program []int
+	// insts holds the reachable instructions, by address.
+	insts map[int]instruction
+	// owner holds, for each cell holding part of a reachable instruction,
+	// the address of that instruction, and -1 for the other cells.
+	owner []int
+	// writers holds, for each constant address written by a reachable
+	// instruction, the addresses of those instructions.
+	writers map[int][]int
+
+	// dynamicJumps is set when a reachable jump has a target only known at
+	// run time, so that code not in insts may run.
+	dynamicJumps bool
+	// relativeWrites is set when a reachable instruction writes relative to
+	// the relative base, anywhere in memory as far as the analysis knows.
+	relativeWrites bool
+
+	warnings []Warning
+}
+
+func analyze(program []int) *analysis {
+	a := &analysis{
+		program: program,
+		insts:   make(map[int]instruction),
+		owner:   make([]int, len(program)),
+		writers: make(map[int][]int),
+	}
+	for i := range a.owner {
+		a.owner[i] = -1
+	}
+
+	queue := []int{0}
+	for len(queue) > 0 {
+		addr := queue[len(queue)-1]
+		queue = queue[:len(queue)-1]
+		if _, ok := a.insts[addr]; ok {
+			continue
+		}
+		next, ok := a.visit(addr)
+		if !ok {
+			continue
+		}
+		for _, n := range next {
+			if n >= len(program) {
+				a.warn(addr, InvalidInstruction, "runs past the end of the program")
+				continue
+			}
+			queue = append(queue, n)
+		}
+	}
+
+	for dest, writers := range a.writers {
+		if dest < 0 || dest >= len(program) || a.owner[dest] < 0 {
+			continue
+		}
+		for _, w := range writers {
+			a.warn(w, SelfModifying, fmt.Sprintf("writes into the instruction at %d", a.owner[dest]))
+		}
+	}
+	sort.SliceStable(a.warnings, func(i, j int) bool {
+		wi, wj := a.warnings[i], a.warnings[j]
+		if wi.Addr != wj.Addr {
+			return wi.Addr < wj.Addr
+		}
+		if wi.Kind != wj.Kind {
+			return wi.Kind < wj.Kind
+		}
+		return wi.Msg < wj.Msg
+	})
+	return a
+}
+
+// visit decodes the reachable instruction at addr, and returns the addresses
+// of the instructions that may run after it, if it is valid.
+func (a *analysis) visit(addr int) ([]int, bool) {
+	ins, next, err := decode(a.program, addr)
+	if err != nil {
+		a.warn(addr, InvalidInstruction, err.Error())
+		return nil, false
+	}
+	a.insts[addr] = ins
+	for i := addr; i < next; i++ {
+		a.owner[i] = addr
+	}
+
+	var dest *parameter
+	switch ins := ins.(type) {
+	case binaryOp:
+		dest = &ins.operands().dest
+	case *inputInstruction:
+		dest = &ins.arg
+	case *haltInstruction:
+		return nil, true
+	case *condJumpInstruction:
+		return a.jump(addr, next, ins), true
+	}
+	if dest != nil {
+		switch dest.mode {
+		case immediateMode:
+			a.warn(addr, ImmediateWrite, "writes through an immediate mode parameter")
+			return nil, false
+		case positionMode:
+			a.writers[dest.value] = append(a.writers[dest.value], addr)
+		case relativeMode:
+			a.relativeWrites = true
+		}
+	}
+	return []int{next}, true
+}
+
+// jump returns the addresses of the instructions that may run after the jump
+// at addr.
+func (a *analysis) jump(addr, next int, ins *condJumpInstruction) []int {
+	taken, skipped := true, true
+	if ins.cond.mode == immediateMode {
+		taken = (ins.cond.value != 0) == ins.jumpOn
+		skipped = !taken
+	}
+	var succ []int
+	if skipped {
+		succ = append(succ, next)
+	}
+	if !taken {
+		return succ
+	}
+	if ins.target.mode != immediateMode {
+		a.dynamicJumps = true
+		return succ
+	}
+	if t := ins.target.value; t < 0 || t >= len(a.program) {
+		a.warn(addr, JumpOutside, fmt.Sprintf("jumps to %d, outside of the program", t))
+		return succ
+	}
+	return append(succ, ins.target.value)
+}
+
+func (a *analysis) warn(addr int, kind WarningKind, msg string) {
+	for _, w := range a.warnings {
+		if w.Addr == addr && w.Kind == kind && w.Msg == msg {
+			return
+		}
+	}
+	a.warnings = append(a.warnings, Warning{addr, kind, msg})
+}
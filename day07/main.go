@@ -6,16 +6,55 @@ import (
 	"fmt"
 	"io/ioutil"
 	"log"
+	"os"
+	"runtime"
 	"strconv"
+	"strings"
 
 	"github.com/campoy/advent-of-code-2019/day07/intcode"
+	"github.com/campoy/advent-of-code-2019/search"
 )
 
 func main() {
 	amplifiers := flag.Int("n", 5, "number of amplifiers")
 	path := flag.String("i", "input.txt", "input program")
+	workers := flag.Int("w", runtime.NumCPU(), "number of parallel workers")
+	phases := flag.String("phases", "5-9", "phase settings to permute, as a range (0-4) or a list (5,6,7,8,9)")
+	mode := flag.String("mode", "feedback", "how amplifiers are connected: serial or feedback")
+	repeat := flag.Bool("repeat", false, "allow the same phase setting on several amplifiers")
+	top := flag.Int("top", 1, "number of best phase settings to report")
+	format := flag.String("format", "text", "output format: text, json or csv")
+	verbose := flag.Bool("v", false, "print the result of every phase setting to stderr")
+	taint := flag.String("taint", "", "report which inputs the last signal of each amplifier depends on, following their data or control flow")
+	record := flag.String("record", "", "write the inputs and outputs of the best run to this replay file")
+	cacheSize := flag.Int("cache", 0, "memoize up to this many amplifier results in serial mode, and report the hit rate to stderr")
 	flag.Parse()
 
+	values, err := parsePhases(*phases)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if !*repeat && len(values) != *amplifiers {
+		log.Fatalf("got %d phase settings for %d amplifiers", len(values), *amplifiers)
+	}
+	if *mode != "serial" && *mode != "feedback" {
+		log.Fatalf("unknown mode %q, expected serial or feedback", *mode)
+	}
+	flow, ok := taintFlows[*taint]
+	if !ok {
+		log.Fatalf("unknown taint flow %q, expected data or control", *taint)
+	}
+	if *cacheSize > 0 && *mode != "serial" {
+		log.Fatal("-cache only applies to serial mode, where amplifiers run one at a time")
+	}
+	if *top < 1 {
+		log.Fatalf("-top must be at least 1, got %d", *top)
+	}
+	write, ok := reportFormats[*format]
+	if !ok {
+		log.Fatalf("unknown format %q, expected text, json or csv", *format)
+	}
+
 	bs, err := ioutil.ReadFile(*path)
 	if err != nil {
 		log.Fatal(err)
@@ -30,78 +69,245 @@ func main() {
 		program = append(program, code)
 	}
 
-	perms := permutations([]int{5, 6, 7, 8, 9})
+	settings := search.Permutations(values)
+	if *repeat {
+		settings = search.Product(values, *amplifiers)
+	}
 
-	maxResult := 0
-	maxSettings := make([]int, *amplifiers)
-	for _, settings := range perms {
-		result, err := runWithSettings(program, *amplifiers, settings)
-		if err != nil {
-			log.Fatal(err)
+	amplifier := intcode.NewFunc(program)
+	if *cacheSize > 0 {
+		amplifier.Cache = intcode.NewCache(*cacheSize)
+	}
+	pool := new(intcode.Pool)
+	s := search.Search{
+		Workers: *workers,
+		NewEvaluator: func() search.Evaluator {
+			return &evaluator{program: program, amplifier: amplifier, pool: pool, feedback: *mode == "feedback"}
+		},
+	}
+
+	best := newTopK(*top)
+	_, err = s.Run(settings, func(res search.Result) {
+		if *verbose {
+			fmt.Fprintln(os.Stderr, res.Candidate, res.Value)
+		}
+		best.add(result{Settings: res.Candidate, Signal: res.Value})
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(best.results) == 0 {
+		log.Fatal("no phase settings to try")
+	}
+	if amplifier.Cache != nil {
+		st := amplifier.Cache.Stats()
+		fmt.Fprintf(os.Stderr, "cache: %d hits, %d misses (%.1f%% hit rate), %d evictions\n",
+			st.Hits, st.Misses, 100*st.HitRate(), st.Evictions)
+	}
+
+	// Run the winning configuration again to record the signals it produced.
+	winner := best.results[0].Settings
+	details := newRunDetails(len(winner), flow)
+	if _, err := runWithSettings(pool, program, winner, *mode == "feedback", details); err != nil {
+		log.Fatal(err)
+	}
+	history := make([][]int, len(winner))
+	for i, rec := range details.recs {
+		for _, r := range rec.Records {
+			if r.Kind == intcode.Output {
+				history[i] = append(history[i], r.Value)
+			}
 		}
-		fmt.Println(settings, result)
-		if result > maxResult {
-			maxResult = result
-			copy(maxSettings, settings)
+	}
+	if *record != "" {
+		if err := writeRecordings(*record, details.recs); err != nil {
+			log.Fatal(err)
 		}
 	}
 
-	fmt.Printf("max result was %d with settings %v\n", maxResult, maxSettings)
+	r := report{Results: best.results, History: history}
+	if *taint != "" {
+		r.Depends = details.taints
+	}
+	if err := write(os.Stdout, r); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// evaluator runs the amplifiers with the phase settings given as candidate.
+// Serial amplifiers are called one after the other, while amplifiers with
+// feedback run together on computers taken from pool.
+type evaluator struct {
+	program   []int
+	amplifier *intcode.Func
+	pool      *intcode.Pool
+	feedback  bool
+}
+
+func (e *evaluator) Eval(settings []int) (int, bool, error) {
+	if !e.feedback {
+		result, err := runSerial(e.amplifier, settings)
+		return result, false, err
+	}
+	result, err := runWithSettings(e.pool, e.program, settings, true, nil)
+	return result, false, err
+}
+
+func writeRecordings(path string, recs []*intcode.Recording) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := intcode.WriteRecordings(f, recs); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
 }
 
-func runWithSettings(program []int, amplifiers int, settings []int) (int, error) {
-	input := make(chan int, 1)
-	output := make(chan int)
-	errc := make(chan error, amplifiers)
+// parsePhases parses either an inclusive range such as 0-4 or a comma
+// separated list of distinct phase settings such as 5,6,7,8,9.
+func parsePhases(text string) ([]int, error) {
+	if parts := strings.Split(text, "-"); len(parts) == 2 {
+		from, err := strconv.Atoi(strings.TrimSpace(parts[0]))
+		if err != nil {
+			return nil, fmt.Errorf("could not parse phase range %q: %v", text, err)
+		}
+		to, err := strconv.Atoi(strings.TrimSpace(parts[1]))
+		if err != nil {
+			return nil, fmt.Errorf("could not parse phase range %q: %v", text, err)
+		}
+		if from > to {
+			return nil, fmt.Errorf("empty phase range %q", text)
+		}
+		var values []int
+		for v := from; v <= to; v++ {
+			values = append(values, v)
+		}
+		return values, nil
+	}
 
-	ch := input
-	for i := 0; i < amplifiers; i++ {
-		ch <- settings[i]
-		out := output
-		if i < amplifiers-1 {
-			out = make(chan int, 1)
+	var values []int
+	seen := make(map[int]bool)
+	for _, part := range strings.Split(text, ",") {
+		v, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil {
+			return nil, fmt.Errorf("could not parse phase %q: %v", part, err)
+		}
+		if seen[v] {
+			return nil, fmt.Errorf("duplicate phase %d in %q", v, text)
 		}
+		seen[v] = true
+		values = append(values, v)
+	}
+	return values, nil
+}
 
-		c := intcode.NewComputer(program, ch, out)
+// runDetails collects what each amplifier did during a run.
+type runDetails struct {
+	recs   []*intcode.Recording
+	flow   intcode.TaintFlow
+	taints []intcode.Taint // the inputs the last signal of each amplifier depends on.
+}
+
+var taintFlows = map[string]intcode.TaintFlow{
+	"":        intcode.DataFlow,
+	"data":    intcode.DataFlow,
+	"control": intcode.ControlFlow,
+}
+
+func newRunDetails(amplifiers int, flow intcode.TaintFlow) *runDetails {
+	d := &runDetails{
+		recs:   make([]*intcode.Recording, amplifiers),
+		flow:   flow,
+		taints: make([]intcode.Taint, amplifiers),
+	}
+	for i := range d.recs {
+		d.recs[i] = new(intcode.Recording)
+	}
+	return d
+}
 
-		go func() { errc <- c.Run() }()
-		ch = out
+// phaseLabels labels the phase setting of the i-th amplifier, and the
+// initial signal given to the first one.
+func phaseLabels(i int) func(n int) intcode.Taint {
+	return func(n int) intcode.Taint {
+		if n == 0 {
+			return intcode.Taint{fmt.Sprintf("phase %d", i)}
+		}
+		return intcode.Taint{"initial signal"}
 	}
+}
 
-	input <- 0
-	lastOutput := 0
-	for {
-		select {
-		case out, ok := <-output:
-			if !ok {
-				return lastOutput, nil
-			}
-			lastOutput = out
-			fmt.Println("output:", out)
-			input <- out
-		case err := <-errc:
-			if err != nil {
-				return 0, err
-			}
+// runSerial calls the amplifier with each phase setting and the last signal
+// produced by the previous call, starting from 0, and returns the last signal
+// produced.
+func runSerial(amplifier *intcode.Func, settings []int) (int, error) {
+	signal := 0
+	for i, phase := range settings {
+		res, err := amplifier.Call(phase, signal)
+		if err != nil {
+			return 0, fmt.Errorf("amplifier %d: %v", i, err)
+		}
+		if n := len(res.Outputs); n > 0 {
+			signal = res.Outputs[n-1]
 		}
 	}
+	return signal, nil
 }
 
-func permutations(values []int) [][]int {
-	if len(values) == 0 {
-		return [][]int{values}
+// runWithSettings runs a chain of amplifiers, one per phase setting, and
+// returns the last signal produced. In feedback mode, the output of the last
+// amplifier is fed back into the first one until they all halt.
+//
+// The computers running the amplifiers are taken from pool, and put back
+// into it once they all stopped. If details is not nil, the inputs and
+// outputs of each amplifier are recorded into it, along with the phase
+// settings its signals depend on.
+func runWithSettings(pool *intcode.Pool, program []int, settings []int, feedback bool, details *runDetails) (int, error) {
+	amplifiers := len(settings)
+
+	// Every channel holds the phase setting and the incoming signal.
+	chans := make([]chan int, amplifiers+1)
+	for i := range chans {
+		chans[i] = make(chan int, 2)
+	}
+	if feedback {
+		chans[amplifiers] = chans[0]
 	}
 
-	var perms [][]int
-	for i, v := range values {
-		rest := make([]int, len(values)-1)
-		copy(rest[:i], values[:i])
-		copy(rest[i:], values[i+1:])
-		for _, perm := range permutations(rest) {
-			perm = append([]int{v}, perm...)
-			perms = append(perms, perm)
+	computers := make([]*intcode.Computer, amplifiers)
+	for i := range computers {
+		chans[i] <- settings[i]
+		computers[i] = pool.Get(program, chans[i], chans[i+1])
+		if details != nil {
+			computers[i].Record(details.recs[i])
+			computers[i].TrackTaint(details.flow, phaseLabels(i))
 		}
 	}
+	defer func() {
+		for _, c := range computers {
+			pool.Put(c)
+		}
+	}()
+	chans[0] <- 0
 
-	return perms
+	lastOutput := 0
+	s := intcode.NewScheduler(computers...)
+	s.Output = func(node, value int) {
+		if node == amplifiers-1 {
+			lastOutput = value
+		}
+	}
+	if details != nil {
+		s.Step = func(node int, ev intcode.Event) {
+			if ev.Kind == intcode.Output {
+				details.taints[node] = ev.Taint
+			}
+		}
+	}
+	if err := s.Run(); err != nil {
+		return 0, err
+	}
+	return lastOutput, nil
 }
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"testing"
+
+	"github.com/campoy/advent-of-code-2019/day07/intcode"
+	"github.com/campoy/advent-of-code-2019/search"
+)
+
+func TestTopK(t *testing.T) {
+	// Results are shown as signal@index, where index is the order in which
+	// they were added.
+	tests := []struct {
+		name    string
+		k       int
+		signals []int
+		want    string
+	}{
+		{"sorted", 3, []int{1, 5, 3, 4, 2}, "[5@1 4@3 3@2]"},
+		{"ties keep the first added", 2, []int{7, 3, 7, 7}, "[7@0 7@2]"},
+		{"tie with the last kept", 2, []int{9, 5, 5}, "[9@0 5@1]"},
+		{"fewer than k", 5, []int{2, 8}, "[8@1 2@0]"},
+		{"none", 3, nil, "[]"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			top := newTopK(test.k)
+			settings := []int{0}
+			for i, s := range test.signals {
+				// add copies the settings, so they can be reused.
+				settings[0] = i
+				top.add(result{Settings: settings, Signal: s})
+			}
+			got := make([]string, len(top.results))
+			for i, r := range top.results {
+				got[i] = fmt.Sprintf("%d@%d", r.Signal, r.Settings[0])
+			}
+			if fmt.Sprint(got) != test.want {
+				t.Fatalf("expected %s; got %v", test.want, got)
+			}
+		})
+	}
+}
+
+func TestParsePhases(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+		err  string
+	}{
+		{text: "0-4", want: "[0 1 2 3 4]"},
+		{text: " 5 - 5 ", want: "[5]"},
+		{text: "5,6,7,8,9", want: "[5 6 7 8 9]"},
+		{text: "9, 3", want: "[9 3]"},
+		{text: "4-0", err: `empty phase range "4-0"`},
+		{text: "a-4", err: `could not parse phase range "a-4": strconv.Atoi: parsing "a": invalid syntax`},
+		{text: "1,,2", err: `could not parse phase "": strconv.Atoi: parsing "": invalid syntax`},
+		{text: "1-2-3", err: `could not parse phase "1-2-3": strconv.Atoi: parsing "1-2-3": invalid syntax`},
+		{text: "1,2,1", err: `duplicate phase 1 in "1,2,1"`},
+	}
+	for _, test := range tests {
+		t.Run(test.text, func(t *testing.T) {
+			got, err := parsePhases(test.text)
+			if test.err != "" {
+				if err == nil || err.Error() != test.err {
+					t.Fatalf("expected error %q; got %v, %v", test.err, got, err)
+				}
+				return
+			}
+			if err != nil || fmt.Sprint(got) != test.want {
+				t.Fatalf("expected %s; got %v, %v", test.want, got, err)
+			}
+		})
+	}
+}
+
+func loadInput(b *testing.B) []int {
+	text, err := ioutil.ReadFile("input.txt")
+	if err != nil {
+		b.Fatal(err)
+	}
+	program, err := intcode.Parse(string(text))
+	if err != nil {
+		b.Fatal(err)
+	}
+	return program
+}
+
+// The benchmarks run the whole search over the phase settings sequentially,
+// once allocating new computers for every evaluation and once reusing them.
+
+func BenchmarkSearchSerial(b *testing.B) {
+	program := loadInput(b)
+	// Both cases step interpreted computers, so they only differ in how the
+	// computers are obtained.
+	run := func(b *testing.B, get func() *intcode.Computer, put func(*intcode.Computer)) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			src := search.Permutations([]int{0, 1, 2, 3, 4})
+			for settings, ok := src.Next(); ok; settings, ok = src.Next() {
+				signal := 0
+				for _, phase := range settings {
+					c := get()
+					c.Provide(phase, signal)
+					for !c.Halted() {
+						ev, err := c.Step()
+						if err != nil {
+							b.Fatal(err)
+						}
+						if ev.Kind == intcode.NeedsInput {
+							b.Fatalf("amplifier needs more than two inputs")
+						}
+						if ev.Kind == intcode.Output {
+							signal = ev.Value
+						}
+					}
+					put(c)
+				}
+			}
+		}
+	}
+	b.Run("fresh", func(b *testing.B) {
+		run(b, func() *intcode.Computer { return intcode.NewComputer(program, nil, nil) }, func(*intcode.Computer) {})
+	})
+	b.Run("pooled", func(b *testing.B) {
+		pool := new(intcode.Pool)
+		run(b, func() *intcode.Computer { return pool.Get(program, nil, nil) }, pool.Put)
+	})
+}
+
+func BenchmarkSearchFeedback(b *testing.B) {
+	program := loadInput(b)
+	run := func(b *testing.B, pool func() *intcode.Pool) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			src := search.Permutations([]int{5, 6, 7, 8, 9})
+			for settings, ok := src.Next(); ok; settings, ok = src.Next() {
+				if _, err := runWithSettings(pool(), program, settings, true, nil); err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+	}
+	b.Run("fresh", func(b *testing.B) {
+		run(b, func() *intcode.Pool { return new(intcode.Pool) })
+	})
+	b.Run("pooled", func(b *testing.B) {
+		pool := new(intcode.Pool)
+		run(b, func() *intcode.Pool { return pool })
+	})
+}
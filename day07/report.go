@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/campoy/advent-of-code-2019/day07/intcode"
+)
+
+type result struct {
+	Settings []int `json:"settings"`
+	Signal   int   `json:"signal"`
+}
+
+// topK keeps the k results with the highest signals. Among equal signals,
+// the ones added first are ranked first.
+type topK struct {
+	k       int
+	results []result
+}
+
+func newTopK(k int) *topK { return &topK{k: k} }
+
+func (t *topK) add(r result) {
+	if len(t.results) == t.k && r.Signal <= t.results[t.k-1].Signal {
+		return
+	}
+	i := len(t.results)
+	for i > 0 && t.results[i-1].Signal < r.Signal {
+		i--
+	}
+	r.Settings = append([]int(nil), r.Settings...)
+	t.results = append(t.results, result{})
+	copy(t.results[i+1:], t.results[i:])
+	t.results[i] = r
+	if len(t.results) > t.k {
+		t.results = t.results[:t.k]
+	}
+}
+
+// report holds the best results, and the signals produced by each amplifier
+// with the winning phase settings.
+type report struct {
+	Results []result `json:"results"`
+	History [][]int  `json:"history"`
+
+	// Depends holds the inputs the last signal of each amplifier depends
+	// on, if requested.
+	Depends []intcode.Taint `json:"depends,omitempty"`
+}
+
+var reportFormats = map[string]func(io.Writer, report) error{
+	"text": writeText,
+	"json": writeJSON,
+	"csv":  writeCSV,
+}
+
+func writeText(w io.Writer, r report) error {
+	best := r.Results[0]
+	fmt.Fprintf(w, "max result was %d with settings %v\n", best.Signal, best.Settings)
+	for i, signals := range r.History {
+		fmt.Fprintf(w, "amplifier %d produced %v\n", i, signals)
+	}
+	for i, taint := range r.Depends {
+		fmt.Fprintf(w, "amplifier %d's last signal depends on %v\n", i, taint)
+	}
+	if len(r.Results) > 1 {
+		fmt.Fprintf(w, "top %d results:\n", len(r.Results))
+		for i, res := range r.Results {
+			fmt.Fprintf(w, "%3d. %d with settings %v\n", i+1, res.Signal, res.Settings)
+		}
+	}
+	return nil
+}
+
+func writeJSON(w io.Writer, r report) error {
+	return json.NewEncoder(w).Encode(r)
+}
+
+// writeCSV writes one row per result. The signal history is not included.
+func writeCSV(w io.Writer, r report) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"rank", "signal", "settings"})
+	for i, res := range r.Results {
+		settings := make([]string, len(res.Settings))
+		for j, v := range res.Settings {
+			settings[j] = strconv.Itoa(v)
+		}
+		cw.Write([]string{strconv.Itoa(i + 1), strconv.Itoa(res.Signal), strings.Join(settings, " ")})
+	}
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,162 @@
+package search
+
+import "sort"
+
+// The sources below generate their candidates lazily, reusing the same slice
+// for every candidate, so they do not allocate after they are created.
+// Callers must not modify the slices they return.
+
+// Permutations returns a Source producing every distinct permutation of the
+// given values, in lexicographic order.
+func Permutations(values []int) Source {
+	p := &permutations{perm: append([]int(nil), values...)}
+	sort.Ints(p.perm)
+	return p
+}
+
+type permutations struct {
+	perm    []int
+	started bool
+	done    bool
+}
+
+func (p *permutations) Next() ([]int, bool) {
+	if p.done {
+		return nil, false
+	}
+	if !p.started {
+		p.started = true
+		return p.perm, true
+	}
+	if !nextPermutation(p.perm) {
+		p.done = true
+		return nil, false
+	}
+	return p.perm, true
+}
+
+// nextPermutation rearranges perm into the next permutation in lexicographic
+// order, returning false if perm was already the last one.
+func nextPermutation(perm []int) bool {
+	i := len(perm) - 2
+	for i >= 0 && perm[i] >= perm[i+1] {
+		i--
+	}
+	if i < 0 {
+		return false
+	}
+	j := len(perm) - 1
+	for perm[j] <= perm[i] {
+		j--
+	}
+	perm[i], perm[j] = perm[j], perm[i]
+	for l, r := i+1, len(perm)-1; l < r; l, r = l+1, r-1 {
+		perm[l], perm[r] = perm[r], perm[l]
+	}
+	return true
+}
+
+// Product returns a Source producing every sequence of n elements taken from
+// values, with repetition, in the order of values.
+func Product(values []int, n int) Source {
+	return &product{
+		values: values,
+		idx:    make([]int, n),
+		cur:    make([]int, n),
+	}
+}
+
+type product struct {
+	values  []int
+	idx     []int
+	cur     []int
+	started bool
+	done    bool
+}
+
+func (p *product) Next() ([]int, bool) {
+	if p.done {
+		return nil, false
+	}
+	if !p.started {
+		p.started = true
+		if len(p.values) == 0 && len(p.idx) > 0 {
+			p.done = true
+			return nil, false
+		}
+	} else if !increment(p.idx, len(p.values)) {
+		p.done = true
+		return nil, false
+	}
+	for i, j := range p.idx {
+		p.cur[i] = p.values[j]
+	}
+	return p.cur, true
+}
+
+// Combinations returns a Source producing every subset of k elements of
+// values, keeping their relative order.
+func Combinations(values []int, k int) Source {
+	c := &combinations{values: values, cur: make([]int, k)}
+	if k > len(values) {
+		c.done = true
+	}
+	c.idx = make([]int, k)
+	for i := range c.idx {
+		c.idx[i] = i
+	}
+	return c
+}
+
+type combinations struct {
+	values  []int
+	idx     []int
+	cur     []int
+	started bool
+	done    bool
+}
+
+func (c *combinations) Next() ([]int, bool) {
+	if c.done {
+		return nil, false
+	}
+	if !c.started {
+		c.started = true
+	} else if !c.advance() {
+		c.done = true
+		return nil, false
+	}
+	for i, j := range c.idx {
+		c.cur[i] = c.values[j]
+	}
+	return c.cur, true
+}
+
+func (c *combinations) advance() bool {
+	n, k := len(c.values), len(c.idx)
+	i := k - 1
+	for i >= 0 && c.idx[i] == n-k+i {
+		i--
+	}
+	if i < 0 {
+		return false
+	}
+	c.idx[i]++
+	for j := i + 1; j < k; j++ {
+		c.idx[j] = c.idx[j-1] + 1
+	}
+	return true
+}
+
+// increment adds one to idx, read as a number in the given base, returning
+// false when it overflows.
+func increment(idx []int, base int) bool {
+	for i := len(idx) - 1; i >= 0; i-- {
+		idx[i]++
+		if idx[i] < base {
+			return true
+		}
+		idx[i] = 0
+	}
+	return false
+}
@@ -0,0 +1,47 @@
+package search
+
+import (
+	"fmt"
+	"testing"
+)
+
+func collect(src Source) []string {
+	var got []string
+	for c, ok := src.Next(); ok; c, ok = src.Next() {
+		got = append(got, fmt.Sprint(c))
+	}
+	return got
+}
+
+func TestSources(t *testing.T) {
+	tt := []struct {
+		name string
+		src  Source
+		want []string
+	}{
+		{"permutations", Permutations([]int{3, 1, 2}), []string{"[1 2 3]", "[1 3 2]", "[2 1 3]", "[2 3 1]", "[3 1 2]", "[3 2 1]"}},
+		{"permutations with duplicates", Permutations([]int{1, 1, 2}), []string{"[1 1 2]", "[1 2 1]", "[2 1 1]"}},
+		{"empty permutation", Permutations(nil), []string{"[]"}},
+		{"product", Product([]int{0, 1}, 2), []string{"[0 0]", "[0 1]", "[1 0]", "[1 1]"}},
+		{"empty product", Product(nil, 2), nil},
+		{"combinations", Combinations([]int{1, 2, 3, 4}, 2), []string{"[1 2]", "[1 3]", "[1 4]", "[2 3]", "[2 4]", "[3 4]"}},
+		{"too many combinations", Combinations([]int{1}, 2), nil},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			got := collect(tc.src)
+			if fmt.Sprint(got) != fmt.Sprint(tc.want) {
+				t.Fatalf("expected %v; got %v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestPermutationsDoNotAllocate(t *testing.T) {
+	src := Permutations([]int{0, 1, 2, 3, 4, 5, 6, 7})
+	allocs := testing.AllocsPerRun(1000, func() { src.Next() })
+	if allocs > 0 {
+		t.Fatalf("expected no allocations; got %v", allocs)
+	}
+}
@@ -0,0 +1,188 @@
+// Package search evaluates candidate inputs in parallel over a pool of
+// workers, while reporting results as if they had been computed one by one.
+package search
+
+import (
+	"runtime"
+	"sync"
+)
+
+// A Source produces the candidates to be evaluated, always in the same order.
+// The slice returned by Next is only valid until the following call.
+type Source interface {
+	Next() ([]int, bool)
+}
+
+// An Evaluator computes the value of a candidate. Each worker owns its own
+// evaluator, so implementations can keep and reuse state between calls
+// without any locking.
+type Evaluator interface {
+	Eval(candidate []int) (value int, hit bool, err error)
+}
+
+// Result holds the evaluation of a single candidate. The Candidate of the
+// results passed to visit is only valid until it returns, since Run reuses it
+// for later candidates; the result returned by Run keeps its own.
+type Result struct {
+	Index     int
+	Candidate []int
+	Value     int
+	Hit       bool
+}
+
+// Search farms the candidates of a Source out to a pool of workers.
+type Search struct {
+	// Workers is the number of concurrent evaluators, runtime.NumCPU() if zero.
+	Workers int
+	// NewEvaluator is called once per worker.
+	NewEvaluator func() Evaluator
+	// StopOnHit makes Run stop at the first candidate that is a hit.
+	StopOnHit bool
+}
+
+type job struct {
+	index     int
+	candidate []int
+}
+
+type outcome struct {
+	Result
+	err error
+}
+
+// Run evaluates the candidates produced by src and calls visit, if not nil,
+// with every result in candidate order, no matter how the workers were
+// scheduled.
+//
+// If StopOnHit is set, Run returns the first hit in candidate order and stops
+// dispatching any later candidates. Errors are handled the same way: the
+// error returned is the one of the earliest failing candidate, and no results
+// after it are visited.
+func (s *Search) Run(src Source, visit func(Result)) (*Result, error) {
+	workers := s.Workers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+
+	var mu sync.Mutex
+	stop := -1 // index of the earliest hit or failure seen, -1 if none.
+	stopped := func(index int) bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return stop >= 0 && index > stop
+	}
+
+	// Sources reuse the slices they return, so every candidate is copied
+	// into a buffer that is recycled once its result has been visited.
+	var free [][]int
+	buffer := func(candidate []int) []int {
+		mu.Lock()
+		defer mu.Unlock()
+		var buf []int
+		if n := len(free); n > 0 {
+			buf, free = free[n-1], free[:n-1]
+		}
+		return append(buf[:0], candidate...)
+	}
+	recycle := func(buf []int) {
+		mu.Lock()
+		defer mu.Unlock()
+		free = append(free, buf)
+	}
+
+	jobs := make(chan job)
+	outcomes := make(chan outcome, workers)
+	done := make(chan struct{})
+	defer close(done)
+
+	go func() {
+		defer close(jobs)
+		for i := 0; !stopped(i); i++ {
+			candidate, ok := src.Next()
+			if !ok {
+				return
+			}
+			j := job{i, buffer(candidate)}
+			select {
+			case jobs <- j:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for w := 0; w < workers; w++ {
+		go func() {
+			defer wg.Done()
+			eval := s.NewEvaluator()
+			for j := range jobs {
+				if stopped(j.index) {
+					continue
+				}
+				value, hit, err := eval.Eval(j.candidate)
+				if (hit && s.StopOnHit) || err != nil {
+					mu.Lock()
+					if stop < 0 || j.index < stop {
+						stop = j.index
+					}
+					mu.Unlock()
+				}
+				o := outcome{Result{j.index, j.candidate, value, hit}, err}
+				select {
+				case outcomes <- o:
+				case <-done:
+					return
+				}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(outcomes)
+	}()
+
+	// Results are buffered until all of the previous ones have arrived.
+	pending := make(map[int]outcome)
+	next := 0
+	for o := range outcomes {
+		pending[o.Index] = o
+		for {
+			o, ok := pending[next]
+			if !ok {
+				break
+			}
+			delete(pending, next)
+			next++
+
+			if o.err != nil {
+				return nil, o.err
+			}
+			if visit != nil {
+				visit(o.Result)
+			}
+			if o.Hit && s.StopOnHit {
+				return &o.Result, nil
+			}
+			recycle(o.Candidate)
+		}
+	}
+	return nil, nil
+}
+
+// Slice is a Source producing the given candidates in order.
+func Slice(candidates [][]int) Source { return &sliceSource{candidates: candidates} }
+
+type sliceSource struct {
+	candidates [][]int
+	next       int
+}
+
+func (s *sliceSource) Next() ([]int, bool) {
+	if s.next >= len(s.candidates) {
+		return nil, false
+	}
+	s.next++
+	return s.candidates[s.next-1], true
+}
@@ -0,0 +1,114 @@
+package search
+
+import (
+	"fmt"
+	"testing"
+)
+
+type squares struct{ hitAt int }
+
+func (e squares) Eval(c []int) (int, bool, error) { return c[0] * c[0], c[0] >= e.hitAt, nil }
+
+type failing struct{ failAt int }
+
+func (e failing) Eval(c []int) (int, bool, error) {
+	if c[0] >= e.failAt {
+		return 0, false, fmt.Errorf("failed at %d", c[0])
+	}
+	return c[0], false, nil
+}
+
+func candidates(n int) Source {
+	var cs [][]int
+	for i := 0; i < n; i++ {
+		cs = append(cs, []int{i})
+	}
+	return Slice(cs)
+}
+
+func TestRunOrder(t *testing.T) {
+	for workers := 1; workers <= 8; workers++ {
+		t.Run(fmt.Sprint(workers), func(t *testing.T) {
+			s := Search{Workers: workers, NewEvaluator: func() Evaluator { return squares{hitAt: 1000} }}
+			var got []int
+			res, err := s.Run(candidates(100), func(r Result) { got = append(got, r.Value) })
+			if err != nil || res != nil {
+				t.Fatalf("expected no hit and no error; got %v, %v", res, err)
+			}
+			if len(got) != 100 {
+				t.Fatalf("expected 100 results; got %d", len(got))
+			}
+			for i, v := range got {
+				if v != i*i {
+					t.Fatalf("expected result %d to be %d; got %d", i, i*i, v)
+				}
+			}
+		})
+	}
+}
+
+func TestRunStopOnHit(t *testing.T) {
+	for workers := 1; workers <= 8; workers++ {
+		t.Run(fmt.Sprint(workers), func(t *testing.T) {
+			s := Search{Workers: workers, NewEvaluator: func() Evaluator { return squares{hitAt: 42} }, StopOnHit: true}
+			visited := 0
+			res, err := s.Run(candidates(100), func(Result) { visited++ })
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res == nil || res.Index != 42 || visited != 43 {
+				t.Fatalf("expected hit at 42 after 43 visits; got %+v after %d", res, visited)
+			}
+		})
+	}
+}
+
+func TestRunError(t *testing.T) {
+	for workers := 1; workers <= 8; workers++ {
+		t.Run(fmt.Sprint(workers), func(t *testing.T) {
+			s := Search{Workers: workers, NewEvaluator: func() Evaluator { return failing{failAt: 10} }}
+			_, err := s.Run(candidates(100), nil)
+			if err == nil || err.Error() != "failed at 10" {
+				t.Fatalf("expected the error of candidate 10; got %v", err)
+			}
+		})
+	}
+}
+
+type weighted struct{}
+
+func (weighted) Eval(c []int) (int, bool, error) {
+	v := 0
+	for i, x := range c {
+		v = 10*v + x*(i+1)
+	}
+	return v, false, nil
+}
+
+func TestRunReusedCandidates(t *testing.T) {
+	var want []string
+	src := Permutations([]int{1, 2, 3, 4, 5})
+	for c, ok := src.Next(); ok; c, ok = src.Next() {
+		want = append(want, fmt.Sprint(c))
+	}
+	for workers := 1; workers <= 8; workers++ {
+		t.Run(fmt.Sprint(workers), func(t *testing.T) {
+			// Permutations and the buffers of Run are both reused, so every
+			// candidate visited must still be the one evaluated.
+			s := Search{Workers: workers, NewEvaluator: func() Evaluator { return weighted{} }}
+			n := 0
+			_, err := s.Run(Permutations([]int{1, 2, 3, 4, 5}), func(r Result) {
+				if got := fmt.Sprint(r.Candidate); got != want[r.Index] {
+					t.Fatalf("expected candidate %d to be %s; got %s", r.Index, want[r.Index], got)
+				}
+				if v, _, _ := (weighted{}).Eval(r.Candidate); v != r.Value {
+					t.Fatalf("expected candidate %v to have value %d; got %d", r.Candidate, v, r.Value)
+				}
+				n++
+			})
+			if err != nil || n != len(want) {
+				t.Fatalf("expected %d results; got %d, %v", len(want), n, err)
+			}
+		})
+	}
+}